@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/rs/zerolog/log"
 	"github.com/spf13/viper"
@@ -20,6 +21,33 @@ type ServerConfig struct {
 	ServerPort string `mapstructure:"server_port"`
 	// CookieKey specifies the key used for encoding the cookies
 	Cookiekey string `mapstructure:"cookie_key"`
+	// CacheTTL specifies how long responses from spotify are cached, e.g. "5m"
+	CacheTTL time.Duration `mapstructure:"cache_ttl"`
+	// DatabasePath specifies where the database storing
+	// api tokens and spotify tokens is kept
+	DatabasePath string `mapstructure:"database_path"`
+	// SnapshotRetention specifies how long snapshots of the top lists
+	// are kept, e.g. "8760h", 0 keeps them forever
+	SnapshotRetention time.Duration `mapstructure:"snapshot_retention"`
+	// SnapshotSchedule specifies when the top lists of users who opted in
+	// are snapshotted in the background, as a crontab line like "0 4 * * *",
+	// empty disables background snapshots
+	SnapshotSchedule string `mapstructure:"snapshot_schedule"`
+	// SnapshotJitter specifies the maximum random delay before a user is
+	// snapshotted, or their subscribed playlists are refreshed, 0 disables it
+	SnapshotJitter time.Duration `mapstructure:"snapshot_jitter"`
+	// SnapshotConcurrency specifies how many users are snapshotted, or have
+	// their subscribed playlists refreshed, at the same time
+	SnapshotConcurrency int `mapstructure:"snapshot_concurrency"`
+	// PlaylistRefreshSchedule specifies when subscribed playlists are refreshed,
+	// as a crontab line like "0 5 * * *", empty disables refreshing them
+	PlaylistRefreshSchedule string `mapstructure:"playlist_refresh_schedule"`
+	// PlaylistCover specifies if a cover is generated for saved playlists,
+	// users have to log in again to grant the image upload scope
+	PlaylistCover bool `mapstructure:"playlist_cover"`
+	// GenreFamiliesPath specifies the json file that groups micro-genres
+	// into parent families on the genres page
+	GenreFamiliesPath string `mapstructure:"genre_families_path"`
 
 	// SpotifyState specifies the string spotify uses to generate unique URLs
 	SpotifyState string `mapstructure:"spotify_state"`
@@ -34,6 +62,27 @@ type ServerConfig struct {
 	// SpotifySecretKey is the client key specified on the spotify
 	// developer portal
 	SpotifySecretKey string `mapstructure:"spotify_secret_key"`
+
+	// LogLevel specifies the minimum level that is logged,
+	// one of trace, debug, info, warn, error, fatal or panic
+	LogLevel string `mapstructure:"log_level"`
+	// LogFormat specifies how logs are written, either json or console
+	LogFormat string `mapstructure:"log_format"`
+	// LogFile specifies a file logs are written to instead of stderr
+	LogFile string `mapstructure:"log_file"`
+	// LogMaxSize specifies the size in megabytes at which the log file is rotated
+	LogMaxSize int `mapstructure:"log_max_size"`
+	// LogMaxBackups specifies how many rotated log files are kept
+	LogMaxBackups int `mapstructure:"log_max_backups"`
+	// LogMaxAge specifies how many days rotated log files are kept
+	LogMaxAge int `mapstructure:"log_max_age"`
+	// LogDebugSampling specifies that only every nth debug message is logged,
+	// 0 or 1 logs all of them
+	LogDebugSampling uint32 `mapstructure:"log_debug_sampling"`
+
+	// ReadyCheckSpotify specifies if /readyz checks that the
+	// spotify accounts service can be resolved
+	ReadyCheckSpotify bool `mapstructure:"ready_check_spotify"`
 }
 
 // Generates the path string
@@ -80,4 +129,19 @@ func setClientDefaults(vip *viper.Viper) {
 	// set default values
 	vip.SetDefault("spotify_state", "secret")
 	vip.SetDefault("cookie_key", "secret")
+	vip.SetDefault("cache_ttl", "5m")
+	vip.SetDefault("database_path", fmt.Sprintf("%s/%s.db", userConfigDir(), strings.ToLower(SoftwareName)))
+	vip.SetDefault("snapshot_retention", "8760h")
+	vip.SetDefault("snapshot_schedule", "0 4 * * *")
+	vip.SetDefault("snapshot_jitter", "15m")
+	vip.SetDefault("snapshot_concurrency", 2)
+	vip.SetDefault("playlist_refresh_schedule", "0 5 * * *")
+	vip.SetDefault("playlist_cover", false)
+	vip.SetDefault("genre_families_path", "web/genres.json")
+	vip.SetDefault("log_level", "info")
+	vip.SetDefault("log_format", "json")
+	vip.SetDefault("log_max_size", 100)
+	vip.SetDefault("log_max_backups", 3)
+	vip.SetDefault("log_max_age", 28)
+	vip.SetDefault("ready_check_spotify", false)
 }
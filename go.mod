@@ -6,7 +6,12 @@ require (
 	github.com/aidarkhanov/nanoid v1.0.8
 	github.com/gorilla/mux v1.8.0
 	github.com/gorilla/sessions v1.2.1
+	github.com/prometheus/client_golang v1.12.2
 	github.com/rs/zerolog v1.25.0
 	github.com/spf13/viper v1.13.0
 	github.com/zmb3/spotify/v2 v2.0.0
+	go.etcd.io/bbolt v1.3.6
+	golang.org/x/image v0.0.0-20220413100746-70e8d0d3baa9
+	golang.org/x/oauth2 v0.0.0-20220411215720-9780585627b5
+	gopkg.in/natefinch/lumberjack.v2 v2.2.1
 )
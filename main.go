@@ -1,9 +1,15 @@
 package main
 
 import (
+	"fmt"
+	"io"
+	"os"
+
 	"github.com/mdanie17/spotifytop/config"
 	"github.com/mdanie17/spotifytop/web"
+	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
+	"gopkg.in/natefinch/lumberjack.v2"
 )
 
 func main() {
@@ -13,6 +19,11 @@ func main() {
 		return
 	}
 
+	if err := setupLogger(cfg); err != nil {
+		log.Error().Err(err).Msg("could not setup logger")
+		return
+	}
+
 	server := web.Web{
 		ServerPort:   cfg.ServerPort,
 		State:        cfg.SpotifyState,
@@ -20,8 +31,57 @@ func main() {
 		CookieKey:    []byte(cfg.Cookiekey),
 		Clientkey:    cfg.SpotifyClientKey,
 		Secretkey:    cfg.SpotifySecretKey,
+		CacheTTL:     cfg.CacheTTL,
+		DatabasePath: cfg.DatabasePath,
+
+		SnapshotRetention:   cfg.SnapshotRetention,
+		SnapshotSchedule:    cfg.SnapshotSchedule,
+		SnapshotJitter:      cfg.SnapshotJitter,
+		SnapshotConcurrency: cfg.SnapshotConcurrency,
+
+		PlaylistRefreshSchedule: cfg.PlaylistRefreshSchedule,
+		PlaylistCover:           cfg.PlaylistCover,
+		GenreFamiliesPath:       cfg.GenreFamiliesPath,
+		ReadyCheckSpotify:       cfg.ReadyCheckSpotify,
 	}
 
 	server.New()
 	server.Run()
 }
+
+// setupLogger configures the global logger from the config
+func setupLogger(cfg config.ServerConfig) error {
+	level, err := zerolog.ParseLevel(cfg.LogLevel)
+	if err != nil {
+		return err
+	}
+	zerolog.SetGlobalLevel(level)
+
+	var out io.Writer = os.Stderr
+	if cfg.LogFile != "" {
+		out = &lumberjack.Logger{
+			Filename:   cfg.LogFile,
+			MaxSize:    cfg.LogMaxSize,
+			MaxBackups: cfg.LogMaxBackups,
+			MaxAge:     cfg.LogMaxAge,
+		}
+	}
+
+	switch cfg.LogFormat {
+	case "json":
+	case "console":
+		out = zerolog.ConsoleWriter{Out: out, NoColor: cfg.LogFile != ""}
+	default:
+		return fmt.Errorf("unsupported log format %q", cfg.LogFormat)
+	}
+
+	logger := zerolog.New(out).With().Timestamp().Logger()
+	if cfg.LogDebugSampling > 1 {
+		logger = logger.Sample(zerolog.LevelSampler{
+			DebugSampler: &zerolog.BasicSampler{N: cfg.LogDebugSampling},
+		})
+	}
+
+	log.Logger = logger
+	return nil
+}
@@ -0,0 +1,119 @@
+package scheduler
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseField(t *testing.T) {
+	minute, dom := fields[0], fields[2]
+
+	tests := []struct {
+		part   string
+		f      field
+		values []int
+	}{
+		{"*", fields[4], []int{0, 1, 2, 3, 4, 5, 6}},
+		{"5", minute, []int{5}},
+		{"1-3", minute, []int{1, 2, 3}},
+		{"1,3,5", minute, []int{1, 3, 5}},
+		{"*/15", minute, []int{0, 15, 30, 45}},
+		{"5/20", minute, []int{5, 25, 45}},
+		{"10-20/5", minute, []int{10, 15, 20}},
+		{"1-2,10/10", dom, []int{1, 2, 10, 20, 30}},
+		{"*/10", dom, []int{1, 11, 21, 31}},
+	}
+
+	for _, test := range tests {
+		bits, err := parseField(test.part, test.f)
+		if err != nil {
+			t.Errorf("parseField(%q) returned error: %v", test.part, err)
+			continue
+		}
+
+		var want uint64
+		for _, v := range test.values {
+			want |= 1 << uint(v)
+		}
+
+		if bits != want {
+			t.Errorf("parseField(%q) = %b, want %b", test.part, bits, want)
+		}
+	}
+}
+
+func TestParseScheduleErrors(t *testing.T) {
+	specs := []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 7",
+		"5-1 * * * *",
+		"*/0 * * * *",
+		"*/x * * * *",
+		"a * * * *",
+		"1-b * * * *",
+		"@yearly",
+	}
+
+	for _, spec := range specs {
+		if _, err := ParseSchedule(spec); err == nil {
+			t.Errorf("ParseSchedule(%q) returned no error", spec)
+		}
+	}
+}
+
+func TestScheduleNext(t *testing.T) {
+	date := func(year int, month time.Month, day, hour, minute int) time.Time {
+		return time.Date(year, month, day, hour, minute, 0, 0, time.UTC)
+	}
+
+	tests := []struct {
+		name string
+		spec string
+		from time.Time
+		want time.Time
+	}{
+		{"every minute", "* * * * *", date(2024, 3, 10, 12, 30), date(2024, 3, 10, 12, 31)},
+		{"seconds are dropped", "* * * * *", date(2024, 3, 10, 12, 30).Add(59 * time.Second), date(2024, 3, 10, 12, 31)},
+		{"step", "*/15 * * * *", date(2024, 3, 10, 12, 31), date(2024, 3, 10, 12, 45)},
+		{"step into next hour", "*/15 * * * *", date(2024, 3, 10, 12, 45), date(2024, 3, 10, 13, 0)},
+		{"range", "0 9-17 * * *", date(2024, 3, 10, 17, 0), date(2024, 3, 11, 9, 0)},
+		{"list", "0 4,16 * * *", date(2024, 3, 10, 4, 0), date(2024, 3, 10, 16, 0)},
+		{"daily later today", "0 4 * * *", date(2024, 3, 10, 3, 59), date(2024, 3, 10, 4, 0)},
+		{"daily tomorrow", "0 4 * * *", date(2024, 3, 10, 4, 0), date(2024, 3, 11, 4, 0)},
+		{"hourly", "@hourly", date(2024, 3, 10, 12, 30), date(2024, 3, 10, 13, 0)},
+		{"daily", "@daily", date(2024, 3, 10, 12, 30), date(2024, 3, 11, 0, 0)},
+		{"midnight", "@midnight", date(2024, 3, 10, 0, 0), date(2024, 3, 11, 0, 0)},
+		// 10 March 2024 is a sunday
+		{"weekly", "@weekly", date(2024, 3, 10, 12, 30), date(2024, 3, 17, 0, 0)},
+		{"monthly", "@monthly", date(2024, 3, 10, 12, 30), date(2024, 4, 1, 0, 0)},
+		{"month rollover", "0 0 * * *", date(2024, 1, 31, 23, 59), date(2024, 2, 1, 0, 0)},
+		{"year rollover", "0 0 * * *", date(2024, 12, 31, 12, 0), date(2025, 1, 1, 0, 0)},
+		{"leap day", "0 0 29 2 *", date(2024, 3, 1, 0, 0), date(2028, 2, 29, 0, 0)},
+		{"skips short months", "0 0 31 * *", date(2024, 4, 1, 0, 0), date(2024, 5, 31, 0, 0)},
+		{"restricted month", "0 0 1 6 *", date(2024, 7, 1, 0, 0), date(2025, 6, 1, 0, 0)},
+		{"only day of week", "0 0 * * 1", date(2024, 3, 10, 0, 0), date(2024, 3, 11, 0, 0)},
+		{"only day of month", "0 0 15 * *", date(2024, 3, 10, 0, 0), date(2024, 3, 15, 0, 0)},
+		// With both day fields restricted either one matching is enough
+		{"day of month or week, week first", "0 0 15 * 1", date(2024, 3, 10, 0, 0), date(2024, 3, 11, 0, 0)},
+		{"day of month or week, month first", "0 0 12 * 5", date(2024, 3, 10, 0, 0), date(2024, 3, 12, 0, 0)},
+		{"never", "0 0 30 2 *", date(2024, 3, 10, 0, 0), time.Time{}},
+	}
+
+	for _, test := range tests {
+		s, err := ParseSchedule(test.spec)
+		if err != nil {
+			t.Errorf("%s: ParseSchedule(%q) returned error: %v", test.name, test.spec, err)
+			continue
+		}
+
+		if got := s.Next(test.from); !got.Equal(test.want) {
+			t.Errorf("%s: Next(%v) of %q = %v, want %v", test.name, test.from, test.spec, got, test.want)
+		}
+	}
+}
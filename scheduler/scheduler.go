@@ -0,0 +1,258 @@
+package scheduler
+
+import (
+	"context"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	defaultConcurrency = 2
+	defaultBackoffBase = time.Hour
+	defaultBackoffMax  = 7 * 24 * time.Hour
+)
+
+// Job is run for every user on every run of the scheduler
+type Job func(ctx context.Context, userID string) error
+
+// Scheduler runs Job for all users returned by Users on Schedule.
+// The start of every job is delayed by a random duration below Jitter,
+// and users whose job failed are skipped until their backoff has passed.
+type Scheduler struct {
+	Schedule *Schedule
+	// Jitter is the maximum random delay before the job of a user starts
+	Jitter time.Duration
+	// Concurrency is the number of jobs running at the same time
+	Concurrency int
+	// BackoffBase is how long a user is skipped after the first failure,
+	// it doubles with every further failure up to BackoffMax
+	BackoffBase time.Duration
+	BackoffMax  time.Duration
+
+	Users func() ([]string, error)
+	Job   Job
+
+	mu      sync.Mutex
+	running bool
+	lastRun time.Time
+	nextRun time.Time
+	users   map[string]*UserStatus
+}
+
+// UserStatus is the result of the last job of a user
+type UserStatus struct {
+	UserID      string
+	LastRun     time.Time
+	LastSuccess time.Time
+	Duration    time.Duration
+	LastError   string
+	// Failures is the number of jobs in a row that failed
+	Failures int
+	// NextAttempt is set when the user is backing off after a failure
+	NextAttempt time.Time
+}
+
+// Status is a snapshot of the state of a Scheduler
+type Status struct {
+	Schedule string
+	Running  bool
+	LastRun  time.Time
+	NextRun  time.Time
+	Users    []UserStatus
+}
+
+// Run runs the scheduler until ctx is cancelled
+func (s *Scheduler) Run(ctx context.Context) {
+	for {
+		next := s.Schedule.Next(time.Now())
+		if next.IsZero() {
+			log.Error().Str("schedule", s.Schedule.String()).Msg("schedule never runs, stopping scheduler")
+			return
+		}
+
+		s.mu.Lock()
+		s.nextRun = next
+		s.mu.Unlock()
+		log.Info().Time("next_run", next).Msg("scheduled next run")
+
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		s.RunOnce(ctx)
+	}
+}
+
+// RunOnce runs the job for every user that is not backing off, and
+// returns when all of them are done
+func (s *Scheduler) RunOnce(ctx context.Context) {
+	users, err := s.Users()
+	if err != nil {
+		log.Error().Err(err).Msg("could not get scheduled users")
+		return
+	}
+
+	s.mu.Lock()
+	if s.running {
+		s.mu.Unlock()
+		log.Warn().Msg("previous run is still running, skipping run")
+		return
+	}
+	s.running = true
+	s.lastRun = time.Now()
+	started := s.lastRun
+	s.setDefaults()
+	s.forgetUsers(users)
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		s.running = false
+		s.mu.Unlock()
+	}()
+
+	sem := make(chan struct{}, s.Concurrency)
+	var wg sync.WaitGroup
+	for _, userID := range users {
+		if !s.due(userID) {
+			continue
+		}
+
+		wg.Add(1)
+		go func(userID string) {
+			defer wg.Done()
+
+			if s.Jitter > 0 {
+				timer := time.NewTimer(time.Duration(rand.Int63n(int64(s.Jitter))))
+				select {
+				case <-ctx.Done():
+					timer.Stop()
+					return
+				case <-timer.C:
+				}
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case sem <- struct{}{}:
+			}
+			defer func() { <-sem }()
+
+			start := time.Now()
+			err := s.Job(ctx, userID)
+			s.record(userID, start, err)
+		}(userID)
+	}
+	wg.Wait()
+
+	log.Info().Int("users", len(users)).Dur("duration", time.Since(started)).Msg("finished scheduled run")
+}
+
+// setDefaults fills in the settings that were not set, the caller must hold s.mu
+func (s *Scheduler) setDefaults() {
+	if s.Concurrency < 1 {
+		s.Concurrency = defaultConcurrency
+	}
+
+	if s.BackoffBase <= 0 {
+		s.BackoffBase = defaultBackoffBase
+	}
+
+	if s.BackoffMax <= 0 {
+		s.BackoffMax = defaultBackoffMax
+	}
+}
+
+// forgetUsers removes the status of users that are no longer scheduled,
+// the caller must hold s.mu
+func (s *Scheduler) forgetUsers(users []string) {
+	if s.users == nil {
+		s.users = make(map[string]*UserStatus)
+	}
+
+	keep := make(map[string]bool, len(users))
+	for _, userID := range users {
+		keep[userID] = true
+	}
+
+	for userID := range s.users {
+		if !keep[userID] {
+			delete(s.users, userID)
+		}
+	}
+}
+
+// due reports whether the user is not backing off from a failure
+func (s *Scheduler) due(userID string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	status, ok := s.users[userID]
+	return !ok || !time.Now().Before(status.NextAttempt)
+}
+
+// record stores the result of the job of a user
+func (s *Scheduler) record(userID string, start time.Time, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	status, ok := s.users[userID]
+	if !ok {
+		status = &UserStatus{UserID: userID}
+		s.users[userID] = status
+	}
+
+	status.LastRun = start
+	status.Duration = time.Since(start)
+	if err == nil {
+		status.LastSuccess = start
+		status.LastError = ""
+		status.Failures = 0
+		status.NextAttempt = time.Time{}
+		return
+	}
+
+	status.LastError = err.Error()
+	status.Failures++
+
+	backoff := s.BackoffBase
+	for i := 1; i < status.Failures && backoff < s.BackoffMax; i++ {
+		backoff *= 2
+	}
+	if backoff > s.BackoffMax {
+		backoff = s.BackoffMax
+	}
+	status.NextAttempt = time.Now().Add(backoff)
+}
+
+// Status returns the state of the scheduler, with the users sorted by id
+func (s *Scheduler) Status() Status {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	status := Status{
+		Schedule: s.Schedule.String(),
+		Running:  s.running,
+		LastRun:  s.lastRun,
+		NextRun:  s.nextRun,
+	}
+
+	for _, user := range s.users {
+		status.Users = append(status.Users, *user)
+	}
+
+	sort.Slice(status.Users, func(i, j int) bool {
+		return status.Users[i].UserID < status.Users[j].UserID
+	})
+
+	return status
+}
@@ -0,0 +1,103 @@
+package storage
+
+import (
+	"encoding/json"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var bucketPlaylists = []byte("playlists")
+
+// Playlist is a top tracks playlist created for a user, so it
+// can be updated instead of creating a new one every time
+type Playlist struct {
+	UserID     string    `json:"user_id"`
+	TimeRange  string    `json:"time_range"`
+	Limit      int       `json:"limit"`
+	PlaylistID string    `json:"playlist_id"`
+	Created    time.Time `json:"created"`
+	Updated    time.Time `json:"updated"`
+	// Subscribed is set when the playlist is refreshed in the background
+	Subscribed bool `json:"subscribed"`
+	// Name and Description are the templates the playlist was saved with,
+	// empty for playlists saved before they could be chosen
+	Name          string `json:"name,omitempty"`
+	Description   string `json:"description,omitempty"`
+	Public        bool   `json:"public"`
+	Collaborative bool   `json:"collaborative"`
+}
+
+func playlistKey(userID, timeRange string, limit int) string {
+	return strings.Join([]string{userID, timeRange, strconv.Itoa(limit)}, "|")
+}
+
+// PlaylistSave stores playlist, replacing the playlist of
+// the same user, time range and limit
+func (s *Storage) PlaylistSave(playlist Playlist) error {
+	return s.put(bucketPlaylists, playlistKey(playlist.UserID, playlist.TimeRange, playlist.Limit), playlist)
+}
+
+// PlaylistGet returns the playlist created for the given user, time range and limit
+func (s *Storage) PlaylistGet(userID, timeRange string, limit int) (Playlist, error) {
+	var playlist Playlist
+	err := s.get(bucketPlaylists, playlistKey(userID, timeRange, limit), &playlist)
+	return playlist, err
+}
+
+// PlaylistDelete forgets the playlist created for the given user, time range and limit
+func (s *Storage) PlaylistDelete(userID, timeRange string, limit int) error {
+	return s.delete(bucketPlaylists, playlistKey(userID, timeRange, limit))
+}
+
+// PlaylistList returns all playlists created for the user, sorted by time range and limit
+func (s *Storage) PlaylistList(userID string) ([]Playlist, error) {
+	var playlists []Playlist
+	err := s.forEachPlaylist(func(playlist Playlist) {
+		if playlist.UserID == userID {
+			playlists = append(playlists, playlist)
+		}
+	})
+
+	sort.Slice(playlists, func(i, j int) bool {
+		if playlists[i].TimeRange != playlists[j].TimeRange {
+			return playlists[i].TimeRange < playlists[j].TimeRange
+		}
+
+		return playlists[i].Limit < playlists[j].Limit
+	})
+
+	return playlists, err
+}
+
+// PlaylistSubscribedUsers returns the ids of all users with a subscribed playlist
+func (s *Storage) PlaylistSubscribedUsers() ([]string, error) {
+	seen := make(map[string]bool)
+	var users []string
+	err := s.forEachPlaylist(func(playlist Playlist) {
+		if playlist.Subscribed && !seen[playlist.UserID] {
+			seen[playlist.UserID] = true
+			users = append(users, playlist.UserID)
+		}
+	})
+
+	sort.Strings(users)
+	return users, err
+}
+
+func (s *Storage) forEachPlaylist(fn func(Playlist)) error {
+	return s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketPlaylists).ForEach(func(k, v []byte) error {
+			var playlist Playlist
+			if err := json.Unmarshal(v, &playlist); err != nil {
+				return err
+			}
+
+			fn(playlist)
+			return nil
+		})
+	})
+}
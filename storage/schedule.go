@@ -0,0 +1,54 @@
+package storage
+
+import (
+	"encoding/json"
+	"sort"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var bucketScheduledUsers = []byte("scheduled_users")
+
+// ScheduledUser is a user that agreed to have their top lists
+// snapshotted in the background
+type ScheduledUser struct {
+	UserID  string    `json:"user_id"`
+	Created time.Time `json:"created"`
+}
+
+// ScheduledUserSave opts the user in to background snapshots
+func (s *Storage) ScheduledUserSave(userID string) error {
+	return s.put(bucketScheduledUsers, userID, ScheduledUser{UserID: userID, Created: time.Now()})
+}
+
+// ScheduledUserGet returns when the user opted in to background snapshots
+func (s *Storage) ScheduledUserGet(userID string) (ScheduledUser, error) {
+	var user ScheduledUser
+	err := s.get(bucketScheduledUsers, userID, &user)
+	return user, err
+}
+
+// ScheduledUserDelete opts the user out of background snapshots
+func (s *Storage) ScheduledUserDelete(userID string) error {
+	return s.delete(bucketScheduledUsers, userID)
+}
+
+// ScheduledUserList returns the ids of all users that opted in to background snapshots
+func (s *Storage) ScheduledUserList() ([]string, error) {
+	var users []string
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketScheduledUsers).ForEach(func(k, v []byte) error {
+			var user ScheduledUser
+			if err := json.Unmarshal(v, &user); err != nil {
+				return err
+			}
+
+			users = append(users, user.UserID)
+			return nil
+		})
+	})
+
+	sort.Strings(users)
+	return users, err
+}
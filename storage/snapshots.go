@@ -0,0 +1,112 @@
+package storage
+
+import (
+	"bytes"
+	"sort"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var bucketSnapshots = []byte("snapshots")
+
+// SnapshotDateLayout is the layout of Snapshot.Date, there is
+// at most one snapshot per user, kind and time range on a date
+const SnapshotDateLayout = "2006-01-02"
+
+// Snapshot is a top list of a user as it was on a date
+type Snapshot struct {
+	UserID    string         `json:"user_id"`
+	Kind      string         `json:"kind"`
+	TimeRange string         `json:"time_range"`
+	Date      string         `json:"date"`
+	Created   time.Time      `json:"created"`
+	Items     []SnapshotItem `json:"items"`
+}
+
+// SnapshotItem is an artist or track in a Snapshot
+type SnapshotItem struct {
+	Rank     int    `json:"rank"`
+	ID       string `json:"id"`
+	Name     string `json:"name"`
+	Subtitle string `json:"subtitle,omitempty"`
+	Image    string `json:"image,omitempty"`
+}
+
+// snapshotKey builds the key of a snapshot, keys of the same
+// user, kind and time range share a prefix and sort by date
+func snapshotKey(userID, kind, timeRange, date string) string {
+	return strings.Join([]string{userID, kind, timeRange, date}, "|")
+}
+
+// snapshotKeyDate returns the date part of a snapshot key
+func snapshotKeyDate(key []byte) string {
+	return string(key[bytes.LastIndexByte(key, '|')+1:])
+}
+
+// SnapshotSave stores snapshot, replacing the snapshot of the same date
+func (s *Storage) SnapshotSave(snapshot Snapshot) error {
+	return s.put(bucketSnapshots, snapshotKey(snapshot.UserID, snapshot.Kind, snapshot.TimeRange, snapshot.Date), snapshot)
+}
+
+// SnapshotGet returns the snapshot of the given date
+func (s *Storage) SnapshotGet(userID, kind, timeRange, date string) (Snapshot, error) {
+	var snapshot Snapshot
+	err := s.get(bucketSnapshots, snapshotKey(userID, kind, timeRange, date), &snapshot)
+	return snapshot, err
+}
+
+// SnapshotDates returns the dates with a snapshot of the given
+// user, kind and time range, newest first
+func (s *Storage) SnapshotDates(userID, kind, timeRange string) ([]string, error) {
+	var dates []string
+	prefix := []byte(snapshotKey(userID, kind, timeRange, ""))
+	err := s.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(bucketSnapshots).Cursor()
+		for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+			dates = append(dates, snapshotKeyDate(k))
+		}
+
+		return nil
+	})
+
+	sort.Sort(sort.Reverse(sort.StringSlice(dates)))
+	return dates, err
+}
+
+// SnapshotPrune deletes all snapshots dated before the date of
+// before, and returns how many were deleted
+func (s *Storage) SnapshotPrune(before time.Time) (int, error) {
+	cutoff := before.Format(SnapshotDateLayout)
+
+	var expired [][]byte
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(bucketSnapshots)
+		err := bucket.ForEach(func(k, v []byte) error {
+			if snapshotKeyDate(k) < cutoff {
+				// k is only valid during the transaction, and the bucket
+				// can not be modified while iterating over it
+				expired = append(expired, append([]byte(nil), k...))
+			}
+
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, k := range expired {
+			if err := bucket.Delete(k); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return len(expired), nil
+}
@@ -0,0 +1,99 @@
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	ErrNotFound = errors.New("not found")
+)
+
+var buckets = [][]byte{
+	bucketAPITokens,
+	bucketSpotifyTokens,
+	bucketSnapshots,
+	bucketScheduledUsers,
+	bucketPlaylists,
+}
+
+// Storage persists data that has to survive a restart in a bolt database
+type Storage struct {
+	db *bolt.DB
+}
+
+// Open opens the database at path, creating it if it does not exist
+func Open(path string) (*Storage, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, bucket := range buckets {
+			if _, err := tx.CreateBucketIfNotExists(bucket); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &Storage{db}, nil
+}
+
+func (s *Storage) Close() error {
+	return s.db.Close()
+}
+
+// Ping checks that the database can be read
+func (s *Storage) Ping() error {
+	return s.db.View(func(tx *bolt.Tx) error {
+		if tx.Bucket(bucketAPITokens) == nil {
+			return errors.New("database is missing buckets")
+		}
+
+		return nil
+	})
+}
+
+func (s *Storage) put(bucket []byte, key string, value interface{}) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucket).Put([]byte(key), data)
+	})
+}
+
+func (s *Storage) get(bucket []byte, key string, value interface{}) error {
+	return s.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(bucket).Get([]byte(key))
+		if data == nil {
+			return ErrNotFound
+		}
+
+		return json.Unmarshal(data, value)
+	})
+}
+
+func (s *Storage) delete(bucket []byte, key string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucket).Delete([]byte(key))
+	})
+}
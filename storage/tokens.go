@@ -0,0 +1,119 @@
+package storage
+
+import (
+	"encoding/json"
+	"sort"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+	"golang.org/x/oauth2"
+)
+
+var (
+	bucketAPITokens     = []byte("api_tokens")
+	bucketSpotifyTokens = []byte("spotify_tokens")
+)
+
+const (
+	ScopeReadTop        = "read-top"
+	ScopeCreatePlaylist = "create-playlist"
+)
+
+// ValidScopes are the scopes an APIToken can be given
+var ValidScopes = []string{ScopeReadTop, ScopeCreatePlaylist}
+
+// APIToken is a personal token a user can use to access the api
+// without a browser session. Only the hash of the token is stored.
+type APIToken struct {
+	ID      string    `json:"id"`
+	Hash    string    `json:"hash"`
+	UserID  string    `json:"user_id"`
+	Name    string    `json:"name"`
+	Scopes  []string  `json:"scopes"`
+	Created time.Time `json:"created"`
+	Expires time.Time `json:"expires"`
+}
+
+// HasScope reports whether the token has been given scope
+func (t APIToken) HasScope(scope string) bool {
+	for _, s := range t.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (t APIToken) Expired() bool {
+	return time.Now().After(t.Expires)
+}
+
+// APITokenSave stores token, keyed by its hash
+func (s *Storage) APITokenSave(token APIToken) error {
+	return s.put(bucketAPITokens, token.Hash, token)
+}
+
+// APITokenGet returns the token with the given hash
+func (s *Storage) APITokenGet(hash string) (APIToken, error) {
+	var token APIToken
+	err := s.get(bucketAPITokens, hash, &token)
+	return token, err
+}
+
+// APITokenList returns all tokens of the user, newest first
+func (s *Storage) APITokenList(userID string) ([]APIToken, error) {
+	var tokens []APIToken
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketAPITokens).ForEach(func(k, v []byte) error {
+			var token APIToken
+			if err := json.Unmarshal(v, &token); err != nil {
+				return err
+			}
+
+			if token.UserID == userID {
+				tokens = append(tokens, token)
+			}
+
+			return nil
+		})
+	})
+
+	sort.Slice(tokens, func(i, j int) bool {
+		return tokens[i].Created.After(tokens[j].Created)
+	})
+
+	return tokens, err
+}
+
+// APITokenDelete deletes the token with the given id, if it belongs to the user
+func (s *Storage) APITokenDelete(userID, id string) error {
+	tokens, err := s.APITokenList(userID)
+	if err != nil {
+		return err
+	}
+
+	for _, token := range tokens {
+		if token.ID == id {
+			return s.delete(bucketAPITokens, token.Hash)
+		}
+	}
+
+	return ErrNotFound
+}
+
+// SpotifyTokenSave stores the spotify token of the user, so
+// spotify can be accessed on their behalf without a browser session
+func (s *Storage) SpotifyTokenSave(userID string, token *oauth2.Token) error {
+	return s.put(bucketSpotifyTokens, userID, token)
+}
+
+// SpotifyTokenGet returns the stored spotify token of the user
+func (s *Storage) SpotifyTokenGet(userID string) (*oauth2.Token, error) {
+	var token oauth2.Token
+	if err := s.get(bucketSpotifyTokens, userID, &token); err != nil {
+		return nil, err
+	}
+
+	return &token, nil
+}
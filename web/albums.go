@@ -0,0 +1,118 @@
+package web
+
+import (
+	"net/http"
+	"sort"
+
+	"github.com/zmb3/spotify/v2"
+)
+
+// topAlbum is an album with some of the top tracks of the user on it
+type topAlbum struct {
+	ID      string   `json:"id"`
+	Name    string   `json:"name"`
+	Artists []string `json:"artists"`
+	Image   string   `json:"image"`
+	URL     string   `json:"url"`
+	// ReleaseYear is 0 when spotify does not know the release date
+	ReleaseYear int `json:"release_year"`
+	// Score is the sum of the rank weights of the top tracks on the album
+	Score  float64         `json:"score"`
+	Tracks []topAlbumTrack `json:"tracks"`
+}
+
+type topAlbumTrack struct {
+	Rank int    `json:"rank"`
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// releaseYear returns the year an album was released, whatever the precision
+// of its release date is
+func releaseYear(album spotify.SimpleAlbum) int {
+	date, _, ok := parseReleaseDate(album)
+	if !ok {
+		return 0
+	}
+
+	return date.Year()
+}
+
+// aggregateAlbums groups the top tracks by album, sorted by the rank weights
+// of their tracks so one hit can outrank a few lower tracks
+func aggregateAlbums(tracks []spotify.FullTrack) []topAlbum {
+	byAlbum := make(map[spotify.ID]int)
+	var albums []topAlbum
+	for i, track := range tracks {
+		index, ok := byAlbum[track.Album.ID]
+		if !ok {
+			album := topAlbum{
+				ID:          track.Album.ID.String(),
+				Name:        track.Album.Name,
+				URL:         track.Album.ExternalURLs["spotify"],
+				ReleaseYear: releaseYear(track.Album),
+			}
+
+			for _, artist := range track.Album.Artists {
+				album.Artists = append(album.Artists, artist.Name)
+			}
+
+			if len(track.Album.Images) > 0 {
+				album.Image = track.Album.Images[0].URL
+			}
+
+			index = len(albums)
+			byAlbum[track.Album.ID] = index
+			albums = append(albums, album)
+		}
+
+		albums[index].Score += rankWeight(i, len(tracks))
+		albums[index].Tracks = append(albums[index].Tracks, topAlbumTrack{Rank: i + 1, ID: track.ID.String(), Name: track.Name})
+	}
+
+	sort.SliceStable(albums, func(i, j int) bool {
+		return albums[i].Score > albums[j].Score
+	})
+
+	return albums
+}
+
+func (w *Web) handleTopAlbums(rw http.ResponseWriter, r *http.Request, rc *requestContext) error {
+	toptracks, err := w.topTracks(r.Context(), rc.Client, rc.User.ID, rc.Settings, r.URL.Query().Get("refresh") != "")
+	if err != nil {
+		return errSpotify(err, "could not get current user top tracks")
+	}
+
+	albums := aggregateAlbums(toptracks)
+	pagination, start, end := paginate(r, len(albums))
+	data := rc.tmplData(albums[start:end])
+	data.Pagination = pagination
+
+	w.templateExec(rw, r, "topalbums", data)
+	return nil
+}
+
+// handleAPITopAlbums derives the top albums from all top tracks of the time
+// range, limit and offset page through the albums
+func (w *Web) handleAPITopAlbums(rw http.ResponseWriter, r *http.Request, rc *requestContext) error {
+	query, err := parseTopQuery(r, rc.Settings)
+	if err != nil {
+		return err
+	}
+
+	toptracks, err := w.topTracks(r.Context(), rc.Client, rc.User.ID, Opts{Timelimit: query.Timelimit, Resultlimit: maxResultLimit}, false)
+	if err != nil {
+		return errSpotify(err, "could not get current user top tracks")
+	}
+
+	albums := aggregateAlbums(toptracks)
+	start, end := query.page(len(albums))
+	writeJSON(rw, r, http.StatusOK, topResponse{
+		TimeRange: query.Timelimit,
+		Limit:     query.Limit,
+		Offset:    query.Offset,
+		Count:     end - start,
+		Items:     albums[start:end],
+	})
+	return nil
+}
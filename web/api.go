@@ -0,0 +1,190 @@
+package web
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/mdanie17/spotifytop/storage"
+)
+
+// topQuery is the parsed query of the top endpoints of the api
+type topQuery struct {
+	Timelimit string
+	Limit     int
+	Offset    int
+}
+
+type playlistResponse struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	URI  string `json:"uri"`
+	URL  string `json:"url"`
+	// Tracks is the number of tracks added to the playlist
+	Tracks  int  `json:"tracks"`
+	Created bool `json:"created"`
+}
+
+type topResponse struct {
+	TimeRange string      `json:"time_range"`
+	Limit     int         `json:"limit"`
+	Offset    int         `json:"offset"`
+	Count     int         `json:"count"`
+	Items     interface{} `json:"items"`
+}
+
+func (w *Web) apiRoutes(r *mux.Router) {
+	r.HandleFunc("/top/artists", w.requireAPILogin(storage.ScopeReadTop, w.handleAPITopArtists)).Methods("GET")
+	r.HandleFunc("/top/tracks", w.requireAPILogin(storage.ScopeReadTop, w.handleAPITopTracks)).Methods("GET")
+	r.HandleFunc("/top/albums", w.requireAPILogin(storage.ScopeReadTop, w.handleAPITopAlbums)).Methods("GET")
+	r.HandleFunc("/playlists", w.requireAPILogin(storage.ScopeCreatePlaylist, w.handleAPICreatePlaylist)).Methods("POST")
+	r.HandleFunc("/history/diff", w.requireAPILogin(storage.ScopeReadTop, w.handleAPIHistoryDiff)).Methods("GET")
+	r.HandleFunc("/audio-features", w.requireAPILogin(storage.ScopeReadTop, w.handleAPIFeatures)).Methods("GET")
+}
+
+// parseTopQuery reads time_range, limit and offset from the query,
+// defaulting to the settings of the user
+func parseTopQuery(r *http.Request, settings Opts) (topQuery, error) {
+	query := topQuery{Timelimit: settings.Timelimit, Limit: settings.Resultlimit}
+	values := r.URL.Query()
+
+	if timelimit := values.Get("time_range"); timelimit != "" {
+		if !checkTimelimit(timelimit) {
+			return query, errUser(fmt.Sprintf("time_range has to be one of %v", ValidTimeLimits))
+		}
+
+		query.Timelimit = timelimit
+	}
+
+	if limit := values.Get("limit"); limit != "" {
+		var err error
+		if query.Limit, err = strconv.Atoi(limit); err != nil || query.Limit < 1 || query.Limit > maxResultLimit {
+			return query, errUser(fmt.Sprintf("limit has to be a number between 1 and %d", maxResultLimit))
+		}
+	}
+
+	if offset := values.Get("offset"); offset != "" {
+		var err error
+		if query.Offset, err = strconv.Atoi(offset); err != nil || query.Offset < 0 {
+			return query, errUser("offset has to be a positive number")
+		}
+	}
+
+	// Only the offset was asked for, so make the default limit fit
+	if values.Get("limit") == "" && query.Limit+query.Offset > maxResultLimit {
+		query.Limit = maxResultLimit - query.Offset
+	}
+
+	if query.Limit < 1 || query.Limit+query.Offset > maxResultLimit {
+		return query, errUser(fmt.Sprintf("limit and offset can at most add up to %d", maxResultLimit))
+	}
+
+	return query, nil
+}
+
+// settings returns the settings needed to fetch everything up to offset+limit
+func (q topQuery) settings() Opts {
+	return Opts{Timelimit: q.Timelimit, Resultlimit: q.Offset + q.Limit}
+}
+
+// page returns the query window of a list with n items
+func (q topQuery) page(n int) (int, int) {
+	if q.Offset > n {
+		return n, n
+	}
+
+	if q.Offset+q.Limit > n {
+		return q.Offset, n
+	}
+
+	return q.Offset, q.Offset + q.Limit
+}
+
+func (w *Web) handleAPITopArtists(rw http.ResponseWriter, r *http.Request, rc *requestContext) error {
+	query, err := parseTopQuery(r, rc.Settings)
+	if err != nil {
+		return err
+	}
+
+	topartists, err := w.topArtists(r.Context(), rc.Client, rc.User.ID, query.settings(), false)
+	if err != nil {
+		return errSpotify(err, "could not get current user top artists")
+	}
+
+	start, end := query.page(len(topartists))
+	writeJSON(rw, r, http.StatusOK, topResponse{
+		TimeRange: query.Timelimit,
+		Limit:     query.Limit,
+		Offset:    query.Offset,
+		Count:     end - start,
+		Items:     topartists[start:end],
+	})
+	return nil
+}
+
+func (w *Web) handleAPITopTracks(rw http.ResponseWriter, r *http.Request, rc *requestContext) error {
+	query, err := parseTopQuery(r, rc.Settings)
+	if err != nil {
+		return err
+	}
+
+	toptracks, err := w.topTracks(r.Context(), rc.Client, rc.User.ID, query.settings(), false)
+	if err != nil {
+		return errSpotify(err, "could not get current user top tracks")
+	}
+
+	start, end := query.page(len(toptracks))
+	writeJSON(rw, r, http.StatusOK, topResponse{
+		TimeRange: query.Timelimit,
+		Limit:     query.Limit,
+		Offset:    query.Offset,
+		Count:     end - start,
+		Items:     toptracks[start:end],
+	})
+	return nil
+}
+
+// handleAPICreatePlaylist saves the top tracks given by time_range and limit in a
+// playlist, replacing or appending to an existing one depending on mode
+func (w *Web) handleAPICreatePlaylist(rw http.ResponseWriter, r *http.Request, rc *requestContext) error {
+	query, err := parseTopQuery(r, rc.Settings)
+	if err != nil {
+		return err
+	}
+
+	mode := r.URL.Query().Get("mode")
+	if mode == "" {
+		mode = defaultPlaylistMode
+	}
+
+	if !checkPlaylistMode(mode) {
+		return errUser(fmt.Sprintf("mode has to be one of %v", playlistModes))
+	}
+
+	settings := Opts{Timelimit: query.Timelimit, Resultlimit: query.Limit}
+	options, err := w.currentPlaylistOptions(rc.User.ID, settings)
+	if err != nil {
+		return errInternal(err, "could not get playlist")
+	}
+
+	result, err := w.saveTopPlaylist(r.Context(), rc.Client, rc.User, settings, mode, options)
+	if err != nil {
+		return errSpotify(err, "could not save playlist")
+	}
+
+	status := http.StatusOK
+	if result.Created {
+		status = http.StatusCreated
+	}
+
+	writeJSON(rw, r, status, playlistResponse{
+		ID:      result.Playlist.ID.String(),
+		Name:    result.Playlist.Name,
+		URI:     string(result.Playlist.URI),
+		URL:     result.Playlist.ExternalURLs["spotify"],
+		Tracks:  result.Added,
+		Created: result.Created,
+	})
+	return nil
+}
@@ -0,0 +1,254 @@
+package web
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/zmb3/spotify/v2"
+)
+
+const (
+	cacheKindArtists = "artists"
+	cacheKindTracks  = "tracks"
+)
+
+const (
+	// spotifyPageLimit is the most items the top endpoints return at once
+	spotifyPageLimit = 50
+	// spotifyMaxOffset is the highest offset the top endpoints accept
+	spotifyMaxOffset = 49
+)
+
+var defaultCacheTTL = 5 * time.Minute
+
+// cacheFetchTimeout bounds a shared fetch, it does not end with the request
+// that started it
+const cacheFetchTimeout = 30 * time.Second
+
+// cacheKey identifies a single response from one of the spotify top endpoints
+type cacheKey struct {
+	UserID      string
+	Kind        string
+	Timelimit   string
+	Resultlimit int
+}
+
+type cacheEntry struct {
+	value   interface{}
+	expires time.Time
+}
+
+// cacheCall is a fetch that is currently in flight, other requests
+// for the same key wait for it instead of calling spotify themselves
+type cacheCall struct {
+	done  chan struct{}
+	value interface{}
+	err   error
+}
+
+// topCache caches the responses of the spotify top endpoints per user,
+// time range and result limit
+type topCache struct {
+	ttl time.Duration
+
+	mu      sync.Mutex
+	entries map[cacheKey]cacheEntry
+	calls   map[cacheKey]*cacheCall
+}
+
+func newTopCache(ttl time.Duration) *topCache {
+	return &topCache{
+		ttl:     ttl,
+		entries: make(map[cacheKey]cacheEntry),
+		calls:   make(map[cacheKey]*cacheCall),
+	}
+}
+
+// get returns the cached value for key, or calls fetch if there is no valid entry.
+// If refresh is set, the cached value is ignored and fetched again.
+// Concurrent calls for the same key share a single fetch, which runs on a
+// context detached from the callers so every caller can give up on its own.
+func (c *topCache) get(ctx context.Context, key cacheKey, refresh bool, fetch func(ctx context.Context) (interface{}, error)) (interface{}, error) {
+	c.mu.Lock()
+	if entry, ok := c.entries[key]; ok && !refresh && time.Now().Before(entry.expires) {
+		c.mu.Unlock()
+		ctxLog(ctx).Debug().Interface("key", key).Msg("cache hit")
+		return entry.value, nil
+	}
+
+	call, ok := c.calls[key]
+	if ok {
+		ctxLog(ctx).Debug().Interface("key", key).Msg("cache miss, waiting for running request")
+	} else {
+		call = &cacheCall{done: make(chan struct{})}
+		c.calls[key] = call
+		ctxLog(ctx).Debug().Interface("key", key).Bool("refresh", refresh).Msg("cache miss")
+		go c.fetch(ctxLog(ctx).WithContext(context.Background()), key, call, fetch)
+	}
+	c.mu.Unlock()
+
+	select {
+	case <-call.done:
+		return call.value, call.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// fetch runs the fetch of call with cacheFetchTimeout and stores the result
+func (c *topCache) fetch(ctx context.Context, key cacheKey, call *cacheCall, fetch func(ctx context.Context) (interface{}, error)) {
+	ctx, cancel := context.WithTimeout(ctx, cacheFetchTimeout)
+	defer cancel()
+
+	call.value, call.err = fetch(ctx)
+
+	c.mu.Lock()
+	delete(c.calls, key)
+	if call.err == nil {
+		c.evictExpired()
+		c.entries[key] = cacheEntry{call.value, time.Now().Add(c.ttl)}
+	}
+	c.mu.Unlock()
+
+	close(call.done)
+}
+
+// evictExpired removes all expired entries, the caller must hold c.mu
+func (c *topCache) evictExpired() {
+	now := time.Now()
+	for key, entry := range c.entries {
+		if now.After(entry.expires) {
+			delete(c.entries, key)
+		}
+	}
+}
+
+// topPage is a single request to one of the spotify top endpoints
+type topPage struct {
+	Offset int
+	Limit  int
+	// Skip is the number of items at the start of the page that
+	// were already returned by the previous page
+	Skip int
+}
+
+// topPages returns the requests needed to get the first n items from a top endpoint
+func topPages(n int) []topPage {
+	if n <= spotifyPageLimit {
+		return []topPage{{0, n, 0}}
+	}
+
+	// The top endpoints do not accept an offset above spotifyMaxOffset, so
+	// the second page starts one item early and that item is skipped
+	return []topPage{
+		{0, spotifyPageLimit, 0},
+		{spotifyMaxOffset, n - spotifyMaxOffset, 1},
+	}
+}
+
+// fetchPages calls fetch for every page concurrently, fetch has
+// to store the result of page i itself
+func fetchPages(pages []topPage, fetch func(i int, page topPage) error) error {
+	errs := make([]error, len(pages))
+
+	var wg sync.WaitGroup
+	for i, page := range pages {
+		wg.Add(1)
+		go func(i int, page topPage) {
+			defer wg.Done()
+			errs[i] = fetch(i, page)
+		}(i, page)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// topArtists returns the users top artists for the given settings, using the cache if possible
+func (w *Web) topArtists(ctx context.Context, client *spotify.Client, userID string, settings Opts, refresh bool) ([]spotify.FullArtist, error) {
+	key := cacheKey{userID, cacheKindArtists, settings.Timelimit, settings.Resultlimit}
+	value, err := w.cache.get(ctx, key, refresh, func(ctx context.Context) (interface{}, error) {
+		pages := topPages(settings.Resultlimit)
+		results := make([][]spotify.FullArtist, len(pages))
+		err := fetchPages(pages, func(i int, page topPage) error {
+			result, err := client.CurrentUsersTopArtists(
+				ctx,
+				spotify.Limit(page.Limit),
+				spotify.Offset(page.Offset),
+				spotify.Timerange(spotify.Range(settings.Timelimit)),
+			)
+			if err != nil {
+				return err
+			}
+
+			if len(result.Artists) > page.Skip {
+				results[i] = result.Artists[page.Skip:]
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		var artists []spotify.FullArtist
+		for _, result := range results {
+			artists = append(artists, result...)
+		}
+
+		w.recordSnapshot(ctx, userID, cacheKindArtists, settings.Timelimit, artistsToRanked(artists))
+		return artists, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return value.([]spotify.FullArtist), nil
+}
+
+// topTracks returns the users top tracks for the given settings, using the cache if possible
+func (w *Web) topTracks(ctx context.Context, client *spotify.Client, userID string, settings Opts, refresh bool) ([]spotify.FullTrack, error) {
+	key := cacheKey{userID, cacheKindTracks, settings.Timelimit, settings.Resultlimit}
+	value, err := w.cache.get(ctx, key, refresh, func(ctx context.Context) (interface{}, error) {
+		pages := topPages(settings.Resultlimit)
+		results := make([][]spotify.FullTrack, len(pages))
+		err := fetchPages(pages, func(i int, page topPage) error {
+			result, err := client.CurrentUsersTopTracks(
+				ctx,
+				spotify.Limit(page.Limit),
+				spotify.Offset(page.Offset),
+				spotify.Timerange(spotify.Range(settings.Timelimit)),
+			)
+			if err != nil {
+				return err
+			}
+
+			if len(result.Tracks) > page.Skip {
+				results[i] = result.Tracks[page.Skip:]
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		var tracks []spotify.FullTrack
+		for _, result := range results {
+			tracks = append(tracks, result...)
+		}
+
+		w.recordSnapshot(ctx, userID, cacheKindTracks, settings.Timelimit, tracksToRanked(tracks))
+		return tracks, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return value.([]spotify.FullTrack), nil
+}
@@ -0,0 +1,85 @@
+package web
+
+import (
+	"fmt"
+	"html"
+	"html/template"
+	"strings"
+)
+
+const (
+	chartWidth      = 600
+	chartBarHeight  = 20
+	chartBarGap     = 6
+	chartLabelWidth = 180
+	// chartLabelChars is the longest label that fits in chartLabelWidth
+	chartLabelChars = 24
+	// chartValueWidth is the space kept right of the longest bar for its text
+	chartValueWidth = 60
+	chartColor      = "#f79862"
+	chartTextColor  = "#ffffff"
+)
+
+// chartBar is a bar in a chart, Text is shown next to it instead of Value
+// when it is set
+type chartBar struct {
+	Label string
+	Value float64
+	Text  string
+}
+
+func (b chartBar) label() string {
+	if runes := []rune(b.Label); len(runes) > chartLabelChars {
+		return string(runes[:chartLabelChars-1]) + "…"
+	}
+
+	return b.Label
+}
+
+func (b chartBar) text() string {
+	if b.Text != "" {
+		return b.Text
+	}
+
+	return fmt.Sprintf("%g", b.Value)
+}
+
+// chartMax returns the largest value of the bars, or 1 when no value is
+// positive so empty charts do not divide by zero
+func chartMax(bars []chartBar) float64 {
+	max := 0.0
+	for _, bar := range bars {
+		if bar.Value > max {
+			max = bar.Value
+		}
+	}
+
+	if max <= 0 {
+		return 1
+	}
+
+	return max
+}
+
+// barChart renders horizontal bars scaled to the largest value as an inline
+// svg, the labels are escaped so the result is safe to put in a template
+func barChart(bars []chartBar) template.HTML {
+	height := len(bars) * (chartBarHeight + chartBarGap)
+	max := chartMax(bars)
+	barWidth := float64(chartWidth - chartLabelWidth - chartValueWidth)
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 %d %d" width="100%%" role="img">`, chartWidth, height)
+	for i, bar := range bars {
+		y := i * (chartBarHeight + chartBarGap)
+		width := barWidth * bar.Value / max
+		textY := y + chartBarHeight*3/4
+
+		fmt.Fprintf(&sb, `<text x="%d" y="%d" fill="%s" font-size="13" text-anchor="end">%s</text>`, chartLabelWidth-8, textY, chartTextColor, html.EscapeString(bar.label()))
+		fmt.Fprintf(&sb, `<rect x="%d" y="%d" width="%.1f" height="%d" fill="%s"><title>%s: %s</title></rect>`, chartLabelWidth, y, width, chartBarHeight, chartColor, html.EscapeString(bar.Label), html.EscapeString(bar.text()))
+		fmt.Fprintf(&sb, `<text x="%.1f" y="%d" fill="%s" font-size="13">%s</text>`, float64(chartLabelWidth)+width+6, textY, chartTextColor, html.EscapeString(bar.text()))
+	}
+	sb.WriteString(`</svg>`)
+
+	return template.HTML(sb.String())
+}
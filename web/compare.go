@@ -0,0 +1,215 @@
+package web
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/zmb3/spotify/v2"
+)
+
+const (
+	trendNew  = "new"
+	trendUp   = "up"
+	trendDown = "down"
+	trendSame = "same"
+	trendGone = "gone"
+)
+
+// rankedItem is an artist or track as shown on the comparison pages
+type rankedItem struct {
+	ID       string
+	Name     string
+	Subtitle string
+	Image    string
+}
+
+// compareItem is an item together with its rank in every time range,
+// a rank of 0 means it is not in the top list of that range
+type compareItem struct {
+	rankedItem
+	Ranks []int
+}
+
+type compareData struct {
+	Kind   string
+	Ranges []string
+	Items  []compareItem
+}
+
+func artistsToRanked(artists []spotify.FullArtist) []rankedItem {
+	items := make([]rankedItem, 0, len(artists))
+	for _, artist := range artists {
+		item := rankedItem{ID: artist.ID.String(), Name: artist.Name, Subtitle: strings.Join(artist.Genres, ", ")}
+		if len(artist.Images) > 0 {
+			item.Image = artist.Images[len(artist.Images)-1].URL
+		}
+
+		items = append(items, item)
+	}
+
+	return items
+}
+
+func tracksToRanked(tracks []spotify.FullTrack) []rankedItem {
+	items := make([]rankedItem, 0, len(tracks))
+	for _, track := range tracks {
+		var artists []string
+		for _, artist := range track.Artists {
+			artists = append(artists, artist.Name)
+		}
+
+		item := rankedItem{ID: track.ID.String(), Name: track.Name, Subtitle: strings.Join(artists, ", ")}
+		if len(track.Album.Images) > 0 {
+			item.Image = track.Album.Images[len(track.Album.Images)-1].URL
+		}
+
+		items = append(items, item)
+	}
+
+	return items
+}
+
+// topRanked returns the top artists or tracks of the user as rankedItems
+func (w *Web) topRanked(ctx context.Context, rc *requestContext, kind string, settings Opts) ([]rankedItem, error) {
+	if kind == cacheKindArtists {
+		artists, err := w.topArtists(ctx, rc.Client, rc.User.ID, settings, false)
+		return artistsToRanked(artists), err
+	}
+
+	tracks, err := w.topTracks(ctx, rc.Client, rc.User.ID, settings, false)
+	return tracksToRanked(tracks), err
+}
+
+// fetchRanges calls fetch for every time range concurrently with the result
+// limit of settings, fetch has to store the result of range i itself
+func fetchRanges(settings Opts, fetch func(i int, settings Opts) error) error {
+	errs := make([]error, len(ValidTimeLimits))
+
+	var wg sync.WaitGroup
+	for i, timelimit := range ValidTimeLimits {
+		wg.Add(1)
+		go func(i int, timelimit string) {
+			defer wg.Done()
+			errs[i] = fetch(i, Opts{timelimit, settings.Resultlimit})
+		}(i, timelimit)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// topTracksAllRanges fetches the top tracks of every time range concurrently,
+// in the order of ValidTimeLimits
+func (w *Web) topTracksAllRanges(ctx context.Context, rc *requestContext) ([][]spotify.FullTrack, error) {
+	lists := make([][]spotify.FullTrack, len(ValidTimeLimits))
+	err := fetchRanges(rc.Settings, func(i int, settings Opts) error {
+		var err error
+		lists[i], err = w.topTracks(ctx, rc.Client, rc.User.ID, settings, false)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return lists, nil
+}
+
+// topRankedAllRanges fetches the top list of every time range concurrently,
+// in the order of ValidTimeLimits
+func (w *Web) topRankedAllRanges(ctx context.Context, rc *requestContext, kind string) ([][]rankedItem, error) {
+	lists := make([][]rankedItem, len(ValidTimeLimits))
+	err := fetchRanges(rc.Settings, func(i int, settings Opts) error {
+		var err error
+		lists[i], err = w.topRanked(ctx, rc, kind, settings)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return lists, nil
+}
+
+// compareRanges merges the top lists of all time ranges, ordered by
+// the shortest time range the items appear in
+func compareRanges(lists [][]rankedItem) []compareItem {
+	var items []compareItem
+	index := make(map[string]int)
+	for i, list := range lists {
+		for rank, item := range list {
+			n, ok := index[item.ID]
+			if !ok {
+				n = len(items)
+				index[item.ID] = n
+				items = append(items, compareItem{rankedItem: item, Ranks: make([]int, len(lists))})
+			}
+
+			items[n].Ranks[i] = rank + 1
+		}
+	}
+
+	return items
+}
+
+// Trend tells how the item moved from the medium term to the short term
+func (c compareItem) Trend() string {
+	short, medium, long := c.Ranks[0], c.Ranks[1], c.Ranks[2]
+	switch {
+	case short != 0 && medium == 0 && long == 0:
+		return trendNew
+	case short == 0:
+		return trendGone
+	case medium == 0 || short < medium:
+		return trendUp
+	case short > medium:
+		return trendDown
+	default:
+		return trendSame
+	}
+}
+
+// Delta is the number of places the item moved from the medium term to the short term,
+// in either direction
+func (c compareItem) Delta() int {
+	if c.Ranks[0] == 0 || c.Ranks[1] == 0 {
+		return 0
+	}
+
+	if c.Ranks[0] > c.Ranks[1] {
+		return c.Ranks[0] - c.Ranks[1]
+	}
+
+	return c.Ranks[1] - c.Ranks[0]
+}
+
+func (w *Web) handleCompare(rw http.ResponseWriter, r *http.Request, rc *requestContext) error {
+	kind := r.URL.Query().Get("kind")
+	if kind != cacheKindArtists {
+		kind = cacheKindTracks
+	}
+
+	lists, err := w.topRankedAllRanges(r.Context(), rc, kind)
+	if err != nil {
+		return errSpotify(err, "could not get top lists for comparison")
+	}
+
+	var ranges []string
+	for _, timelimit := range ValidTimeLimits {
+		ranges = append(ranges, Opts{Timelimit: timelimit}.TimeLimitFormatter())
+	}
+
+	w.templateExec(rw, r, "compare", rc.tmplData(compareData{
+		Kind:   kind,
+		Ranges: ranges,
+		Items:  compareRanges(lists),
+	}))
+	return nil
+}
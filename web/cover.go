@@ -0,0 +1,246 @@
+package web
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	// Album art is jpeg, but decode png as well in case spotify serves it
+	_ "image/png"
+
+	"github.com/zmb3/spotify/v2"
+	"golang.org/x/image/draw"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/font/sfnt"
+	"golang.org/x/image/math/fixed"
+)
+
+const (
+	coverSize = 640
+	// coverMaxBytes is the largest jpeg accepted by spotify, which limits
+	// the base64 encoded image to 256 KB
+	coverMaxBytes = 256 * 1024 * 3 / 4
+	// coverFontSize is the height of the text in pixels
+	coverFontSize    = 44
+	coverTextPadding = 16
+	coverTimeout     = 10 * time.Second
+)
+
+var coverBackground = color.RGBA{0x19, 0x14, 0x14, 0xff}
+
+// coverGridSize returns how many album covers fit in a row, the grid is
+// the largest square that can be filled by distinct albums
+func coverGridSize(albums int) int {
+	switch {
+	case albums >= 9:
+		return 3
+	case albums >= 4:
+		return 2
+	default:
+		return 1
+	}
+}
+
+// coverAlbumImages returns the image urls of the distinct albums of the tracks
+// in order, picking the smallest image that still fills a grid cell
+func coverAlbumImages(tracks []spotify.FullTrack) []string {
+	seen := make(map[spotify.ID]bool)
+	var urls []string
+	for _, track := range tracks {
+		if seen[track.Album.ID] || len(track.Album.Images) == 0 {
+			continue
+		}
+		seen[track.Album.ID] = true
+
+		// Spotify lists the images widest first
+		url := track.Album.Images[0].URL
+		for _, img := range track.Album.Images {
+			if img.Width >= coverSize/3 {
+				url = img.URL
+			}
+		}
+		urls = append(urls, url)
+	}
+
+	return urls
+}
+
+// fetchCoverImage downloads and decodes an album image
+func fetchCoverImage(ctx context.Context, url string) (image.Image, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	img, _, err := image.Decode(resp.Body)
+	return img, err
+}
+
+// generateCover draws a playlist cover with the user name, time range and date
+// over a grid of the album art of the tracks, encoded as jpeg
+func generateCover(ctx context.Context, user *spotify.PrivateUser, settings Opts, tracks []spotify.FullTrack) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, coverTimeout)
+	defer cancel()
+
+	urls := coverAlbumImages(tracks)
+	grid := coverGridSize(len(urls))
+	if len(urls) > grid*grid {
+		urls = urls[:grid*grid]
+	}
+
+	images := make([]image.Image, len(urls))
+	var wg sync.WaitGroup
+	for i, url := range urls {
+		wg.Add(1)
+		go func(i int, url string) {
+			defer wg.Done()
+
+			img, err := fetchCoverImage(ctx, url)
+			if err != nil {
+				// A missing album leaves its cell empty
+				ctxLog(ctx).Warn().Err(err).Str("url", url).Msg("could not get album image for cover")
+				return
+			}
+			images[i] = img
+		}(i, url)
+	}
+	wg.Wait()
+
+	cover := image.NewRGBA(image.Rect(0, 0, coverSize, coverSize))
+	draw.Draw(cover, cover.Bounds(), image.NewUniform(coverBackground), image.Point{}, draw.Src)
+
+	cell := coverSize / grid
+	for i, img := range images {
+		if img == nil {
+			continue
+		}
+
+		x, y := i%grid*cell, i/grid*cell
+		draw.ApproxBiLinear.Scale(cover, image.Rect(x, y, x+cell, y+cell), img, img.Bounds(), draw.Src, nil)
+	}
+
+	year, month, day := time.Now().Date()
+	err := drawCoverText(cover, []string{
+		user.DisplayName,
+		settings.TimeLimitFormatter(),
+		fmt.Sprintf("%d %s %d", day, month, year),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return encodeCover(cover)
+}
+
+// coverDrawable drops the characters the font has no glyph for, instead of
+// drawing them as boxes. The Go font covers latin, greek and cyrillic.
+func coverDrawable(f *opentype.Font, s string) string {
+	var buf sfnt.Buffer
+	s = strings.Map(func(r rune) rune {
+		if index, err := f.GlyphIndex(&buf, r); err != nil || index == 0 {
+			return -1
+		}
+
+		return r
+	}, s)
+
+	return strings.Join(strings.Fields(s), " ")
+}
+
+// drawCoverText draws the lines on a dark band at the bottom of the cover,
+// lines that are too wide are cut off and empty lines are left out
+func drawCoverText(cover *image.RGBA, lines []string) error {
+	f, err := opentype.Parse(goregular.TTF)
+	if err != nil {
+		return err
+	}
+
+	face, err := opentype.NewFace(f, &opentype.FaceOptions{Size: coverFontSize, DPI: 72, Hinting: font.HintingFull})
+	if err != nil {
+		return err
+	}
+	defer face.Close()
+
+	var drawable []string
+	for _, line := range lines {
+		if line = coverDrawable(f, line); line != "" {
+			drawable = append(drawable, line)
+		}
+	}
+
+	metrics := face.Metrics()
+	lineHeight := metrics.Height.Ceil()
+	maxWidth := fixed.I(coverSize - 2*coverTextPadding)
+
+	height := lineHeight*len(drawable) + 2*coverTextPadding
+	band := image.Rect(0, coverSize-height, coverSize, coverSize)
+	draw.Draw(cover, band, image.NewUniform(color.RGBA{0, 0, 0, 0xb0}), image.Point{}, draw.Over)
+
+	drawer := font.Drawer{Dst: cover, Src: image.White, Face: face}
+	for i, line := range drawable {
+		for runes := []rune(line); drawer.MeasureString(line) > maxWidth && len(runes) > 1; {
+			runes = runes[:len(runes)-1]
+			line = strings.TrimSpace(string(runes)) + "…"
+		}
+
+		drawer.Dot = fixed.P(coverTextPadding, band.Min.Y+coverTextPadding+lineHeight*i+metrics.Ascent.Ceil())
+		drawer.DrawString(line)
+	}
+
+	return nil
+}
+
+// encodeCover encodes the cover as jpeg, lowering the quality until it is
+// small enough for spotify
+func encodeCover(cover image.Image) ([]byte, error) {
+	var buf bytes.Buffer
+	for quality := 90; quality > 0; quality -= 20 {
+		buf.Reset()
+		if err := jpeg.Encode(&buf, cover, &jpeg.Options{Quality: quality}); err != nil {
+			return nil, err
+		}
+
+		if buf.Len() <= coverMaxBytes {
+			return buf.Bytes(), nil
+		}
+	}
+
+	return nil, fmt.Errorf("cover is larger than %d bytes", coverMaxBytes)
+}
+
+// uploadCover sets a generated cover on the playlist. A failed upload is only
+// logged, the playlist is still usable with spotify's default cover.
+func (w *Web) uploadCover(ctx context.Context, client *spotify.Client, user *spotify.PrivateUser, settings Opts, id spotify.ID, tracks []spotify.FullTrack) {
+	if !w.PlaylistCover {
+		return
+	}
+
+	cover, err := generateCover(ctx, user, settings, tracks)
+	if err != nil {
+		ctxLog(ctx).Error().Err(err).Msg("could not generate playlist cover")
+		return
+	}
+
+	if err := client.SetPlaylistImage(ctx, id, bytes.NewReader(cover)); err != nil {
+		ctxLog(ctx).Error().Err(err).Str("playlist", id.String()).Msg("could not upload playlist cover")
+	}
+}
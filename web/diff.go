@@ -0,0 +1,230 @@
+package web
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/mdanie17/spotifytop/storage"
+)
+
+// diffMovers is the number of biggest movers shown in a diff
+const diffMovers = 5
+
+// diffItem is an item of a snapshot together with how its rank changed,
+// a rank of 0 means it is not in that snapshot
+type diffItem struct {
+	ID       string `json:"id"`
+	Name     string `json:"name"`
+	Subtitle string `json:"subtitle,omitempty"`
+	Image    string `json:"image,omitempty"`
+	FromRank int    `json:"from_rank"`
+	ToRank   int    `json:"to_rank"`
+	// Change is the number of places the item climbed,
+	// negative if it dropped
+	Change int `json:"change"`
+	// New is set for items that were not in the older snapshot, an item
+	// ranked below the end of the older snapshot is not known to be new
+	New bool `json:"new"`
+}
+
+// snapshotDiff is the difference between the snapshots of two dates
+type snapshotDiff struct {
+	Kind      string `json:"kind"`
+	TimeRange string `json:"time_range"`
+	From      string `json:"from"`
+	To        string `json:"to"`
+	// Items are the items of the newer snapshot, in order
+	Items   []diffItem `json:"items"`
+	Added   []diffItem `json:"added"`
+	Removed []diffItem `json:"removed"`
+	// Movers are the items that changed their rank the most
+	Movers []diffItem `json:"movers"`
+}
+
+type diffData struct {
+	historyData
+	Diff snapshotDiff
+}
+
+// Moved is the number of places the item moved, in either direction
+func (d diffItem) Moved() int {
+	if d.Change < 0 {
+		return -d.Change
+	}
+
+	return d.Change
+}
+
+func newDiffItem(item storage.SnapshotItem) diffItem {
+	return diffItem{ID: item.ID, Name: item.Name, Subtitle: item.Subtitle, Image: item.Image}
+}
+
+// diffSnapshots compares the snapshot from with the newer snapshot to. The
+// snapshots can have different lengths, so an item is only added if its new
+// rank is one the older snapshot has, and only removed if its old rank is one
+// the newer snapshot has.
+func diffSnapshots(from, to storage.Snapshot) snapshotDiff {
+	diff := snapshotDiff{
+		Kind:      to.Kind,
+		TimeRange: to.TimeRange,
+		From:      from.Date,
+		To:        to.Date,
+		Items:     []diffItem{},
+		Added:     []diffItem{},
+		Removed:   []diffItem{},
+		Movers:    []diffItem{},
+	}
+
+	fromRanks := make(map[string]int, len(from.Items))
+	for _, item := range from.Items {
+		fromRanks[item.ID] = item.Rank
+	}
+
+	toRanks := make(map[string]int, len(to.Items))
+	for _, item := range to.Items {
+		toRanks[item.ID] = item.Rank
+
+		d := newDiffItem(item)
+		d.ToRank = item.Rank
+		d.FromRank = fromRanks[item.ID]
+		if d.FromRank == 0 {
+			if d.ToRank <= len(from.Items) {
+				d.New = true
+				diff.Added = append(diff.Added, d)
+			}
+		} else {
+			d.Change = d.FromRank - d.ToRank
+			if d.Change != 0 {
+				diff.Movers = append(diff.Movers, d)
+			}
+		}
+
+		diff.Items = append(diff.Items, d)
+	}
+
+	for _, item := range from.Items {
+		if toRanks[item.ID] == 0 && item.Rank <= len(to.Items) {
+			d := newDiffItem(item)
+			d.FromRank = item.Rank
+			diff.Removed = append(diff.Removed, d)
+		}
+	}
+
+	sort.SliceStable(diff.Movers, func(i, j int) bool {
+		return diff.Movers[i].Moved() > diff.Movers[j].Moved()
+	})
+	if len(diff.Movers) > diffMovers {
+		diff.Movers = diff.Movers[:diffMovers]
+	}
+
+	return diff
+}
+
+// loadSnapshotDiff compares the snapshots of the user from the dates from and to.
+// If to is empty the newest snapshot is used, if from is empty the one before to.
+func (w *Web) loadSnapshotDiff(userID, kind, timelimit, from, to string) (snapshotDiff, []string, error) {
+	if kind != cacheKindArtists && kind != cacheKindTracks {
+		return snapshotDiff{}, nil, errUser(fmt.Sprintf("kind has to be %s or %s", cacheKindArtists, cacheKindTracks))
+	}
+
+	if !checkTimelimit(timelimit) {
+		return snapshotDiff{}, nil, errUser(fmt.Sprintf("time range has to be one of %v", ValidTimeLimits))
+	}
+
+	// The dates come from the query, so they are not put in the message
+	for _, date := range []string{from, to} {
+		if _, err := time.Parse(storage.SnapshotDateLayout, date); date != "" && err != nil {
+			return snapshotDiff{}, nil, errUser(fmt.Sprintf("Dates have to be like %s", storage.SnapshotDateLayout))
+		}
+	}
+
+	dates, err := w.Storage.SnapshotDates(userID, kind, timelimit)
+	if err != nil {
+		return snapshotDiff{}, nil, errInternal(err, "could not get snapshot dates")
+	}
+
+	if to == "" && len(dates) > 0 {
+		to = dates[0]
+	}
+
+	if from == "" {
+		for _, date := range dates {
+			if date < to {
+				from = date
+				break
+			}
+		}
+	}
+
+	if from == "" || to == "" {
+		return snapshotDiff{}, dates, errNotFound("There is no earlier snapshot to compare with")
+	}
+
+	snapshots := make([]storage.Snapshot, 2)
+	for i, date := range []string{from, to} {
+		snapshots[i], err = w.Storage.SnapshotGet(userID, kind, timelimit, date)
+		if errors.Is(err, storage.ErrNotFound) {
+			return snapshotDiff{}, dates, errNotFound(fmt.Sprintf("There is no snapshot from %s", date))
+		}
+		if err != nil {
+			return snapshotDiff{}, dates, errInternal(err, "could not get snapshot")
+		}
+	}
+
+	// Always compare the older snapshot with the newer one
+	if snapshots[0].Date > snapshots[1].Date {
+		snapshots[0], snapshots[1] = snapshots[1], snapshots[0]
+	}
+
+	return diffSnapshots(snapshots[0], snapshots[1]), dates, nil
+}
+
+// handleHistoryDiff shows the difference between two snapshots
+func (w *Web) handleHistoryDiff(rw http.ResponseWriter, r *http.Request, rc *requestContext) error {
+	query := r.URL.Query()
+	kind := query.Get("kind")
+	if kind == "" {
+		kind = cacheKindTracks
+	}
+
+	timelimit := query.Get("range")
+	if timelimit == "" {
+		timelimit = rc.Settings.Timelimit
+	}
+
+	diff, dates, err := w.loadSnapshotDiff(rc.User.ID, kind, timelimit, query.Get("from"), query.Get("to"))
+	if err != nil {
+		return err
+	}
+
+	w.templateExec(rw, r, "diff", rc.tmplData(diffData{
+		historyData: historyData{Kind: kind, TimeRange: timelimit, Dates: dates},
+		Diff:        diff,
+	}))
+	return nil
+}
+
+// handleAPIHistoryDiff returns the difference between two snapshots as json
+func (w *Web) handleAPIHistoryDiff(rw http.ResponseWriter, r *http.Request, rc *requestContext) error {
+	query := r.URL.Query()
+	kind := query.Get("kind")
+	if kind == "" {
+		kind = cacheKindTracks
+	}
+
+	timelimit := query.Get("time_range")
+	if timelimit == "" {
+		timelimit = rc.Settings.Timelimit
+	}
+
+	diff, _, err := w.loadSnapshotDiff(rc.User.ID, kind, timelimit, query.Get("from"), query.Get("to"))
+	if err != nil {
+		return err
+	}
+
+	writeJSON(rw, r, http.StatusOK, diff)
+	return nil
+}
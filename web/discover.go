@@ -0,0 +1,200 @@
+package web
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/zmb3/spotify/v2"
+)
+
+const (
+	// Seeds taken from each top list, spotify accepts 5 seeds in total
+	discoverArtistSeeds = 2
+	discoverTrackSeeds  = 2
+	discoverGenreSeeds  = 1
+
+	// maxDiscoverTracks is the most recommendations spotify returns at once
+	maxDiscoverTracks = 100
+
+	discoverPlaylistName        = "{user} Discover - {range}"
+	discoverPlaylistDescription = "Recommendations based on the top artists and tracks of {user} - {range} | {action} {date}"
+)
+
+type discoverData struct {
+	// Energy and Popularity are the targets from 0 to 100, or empty for none
+	Energy     string
+	Popularity string
+	Seeds      []string
+	Tracks     []spotify.SimpleTrack
+}
+
+// parseTarget parses an optional target attribute between 0 and 100
+func parseTarget(value, name string) (int, bool, error) {
+	if value == "" {
+		return 0, false, nil
+	}
+
+	target, err := strconv.Atoi(value)
+	if err != nil || target < 0 || target > 100 {
+		return 0, false, errUser(fmt.Sprintf("%s has to be a number between 0 and 100", name))
+	}
+
+	return target, true, nil
+}
+
+// topGenres returns the genres of the artists, the most common first
+func topGenres(artists []spotify.FullArtist) []string {
+	counts := make(map[string]int)
+	var genres []string
+	for _, artist := range artists {
+		for _, genre := range artist.Genres {
+			if counts[genre] == 0 {
+				genres = append(genres, genre)
+			}
+			counts[genre]++
+		}
+	}
+
+	sort.SliceStable(genres, func(i, j int) bool {
+		return counts[genres[i]] > counts[genres[j]]
+	})
+
+	return genres
+}
+
+// discoverSeeds picks the recommendation seeds from the top artists and tracks
+// of the user, and returns the names of the seeds for display
+func (w *Web) discoverSeeds(ctx context.Context, rc *requestContext) (spotify.Seeds, []string, error) {
+	var seeds spotify.Seeds
+	var names []string
+
+	artists, err := w.topArtists(ctx, rc.Client, rc.User.ID, rc.Settings, false)
+	if err != nil {
+		return seeds, nil, err
+	}
+
+	tracks, err := w.topTracks(ctx, rc.Client, rc.User.ID, rc.Settings, false)
+	if err != nil {
+		return seeds, nil, err
+	}
+
+	for i := 0; i < len(artists) && i < discoverArtistSeeds; i++ {
+		seeds.Artists = append(seeds.Artists, artists[i].ID)
+		names = append(names, artists[i].Name)
+	}
+
+	for i := 0; i < len(tracks) && i < discoverTrackSeeds; i++ {
+		seeds.Tracks = append(seeds.Tracks, tracks[i].ID)
+		names = append(names, tracks[i].Name)
+	}
+
+	// Only some genres are accepted as seeds
+	available, err := rc.Client.GetAvailableGenreSeeds(ctx)
+	if err != nil {
+		ctxLog(ctx).Warn().Err(err).Msg("could not get available genre seeds")
+		return seeds, names, nil
+	}
+
+	valid := make(map[string]bool)
+	for _, genre := range available {
+		valid[genre] = true
+	}
+
+	for _, genre := range topGenres(artists) {
+		if len(seeds.Genres) == discoverGenreSeeds {
+			break
+		}
+
+		if valid[genre] {
+			seeds.Genres = append(seeds.Genres, genre)
+			names = append(names, genre)
+		}
+	}
+
+	return seeds, names, nil
+}
+
+// handleDiscover previews recommendations seeded from the top artists, tracks
+// and genres of the user, tuned by the energy and popularity query parameters
+func (w *Web) handleDiscover(rw http.ResponseWriter, r *http.Request, rc *requestContext) error {
+	data := discoverData{
+		Energy:     r.URL.Query().Get("energy"),
+		Popularity: r.URL.Query().Get("popularity"),
+	}
+
+	attributes := spotify.NewTrackAttributes()
+	energy, ok, err := parseTarget(data.Energy, "Energy")
+	if err != nil {
+		return err
+	} else if ok {
+		attributes.TargetEnergy(float64(energy) / 100)
+	}
+
+	popularity, ok, err := parseTarget(data.Popularity, "Popularity")
+	if err != nil {
+		return err
+	} else if ok {
+		attributes.TargetPopularity(popularity)
+	}
+
+	seeds, names, err := w.discoverSeeds(r.Context(), rc)
+	if err != nil {
+		return errSpotify(err, "could not get recommendation seeds")
+	}
+	data.Seeds = names
+
+	if len(names) > 0 {
+		limit := rc.Settings.Resultlimit
+		if limit > maxDiscoverTracks {
+			limit = maxDiscoverTracks
+		}
+
+		recommendations, err := rc.Client.GetRecommendations(r.Context(), seeds, attributes, spotify.Limit(limit))
+		if err != nil {
+			return errSpotify(err, "could not get recommendations")
+		}
+		data.Tracks = recommendations.Tracks
+	}
+
+	w.templateExec(rw, r, "discover", rc.tmplData(data))
+	return nil
+}
+
+// handleDiscoverPlaylist saves the previewed recommendations, posted as track
+// ids, in a new playlist
+func (w *Web) handleDiscoverPlaylist(rw http.ResponseWriter, r *http.Request, rc *requestContext) error {
+	if err := r.ParseForm(); err != nil {
+		return errUser("Could not read the form")
+	}
+
+	var trackIDs []spotify.ID
+	for _, id := range r.PostForm["track"] {
+		trackIDs = append(trackIDs, spotify.ID(id))
+	}
+
+	if len(trackIDs) == 0 || len(trackIDs) > maxDiscoverTracks {
+		return errUser(fmt.Sprintf("A playlist has to have between 1 and %d tracks", maxDiscoverTracks))
+	}
+
+	now := time.Now()
+	name := expandPlaylistTemplate(discoverPlaylistName, rc.User, rc.Settings, playlistActionCreated, now)
+	description := expandPlaylistTemplate(discoverPlaylistDescription, rc.User, rc.Settings, playlistActionCreated, now)
+
+	playlist, err := rc.Client.CreatePlaylistForUser(r.Context(), rc.User.ID, name, description, false, false)
+	if err != nil {
+		return errSpotify(err, "could not create playlist")
+	}
+	w.metrics.playlistsCreated.Inc()
+
+	if _, err := rc.Client.AddTracksToPlaylist(r.Context(), playlist.ID, trackIDs...); err != nil {
+		return errSpotify(err, "could not add tracks to playlist")
+	}
+
+	w.addFlash(rw, r, flashMessage{flashLevelSuccess, fmt.Sprintf("Saved %d recommendations in playlist %s", len(trackIDs), playlist.Name)})
+	redirectReferer(rw, r)
+	return nil
+}
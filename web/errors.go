@@ -0,0 +1,151 @@
+package web
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+const (
+	msgSpotifyError = "Could not communicate with Spotify - Try clearing cache and trying again"
+	msgInternal     = "Something went wrong - Try again later"
+)
+
+// webError is an error returned from a handler, it describes
+// both what is logged and what is shown to the user
+type webError struct {
+	// Err is the underlying error, it is logged together with LogMessage
+	Err        error
+	LogMessage string
+
+	// Level and Message are shown to the user as a flash message
+	Level   flashLevel
+	Message string
+	// Status is the http status code used when the error is not shown as a flash
+	Status int
+	// Redirect is where the user is sent after the flash has been added,
+	// if empty the user is sent back to the referer
+	Redirect string
+}
+
+var (
+	errNotLoggedIn = &webError{
+		Level:   flashLevelDanger,
+		Message: "You have to log in first",
+		Status:  http.StatusUnauthorized,
+	}
+	errSessionExpired = &webError{
+		Level:    flashLevelWarning,
+		Message:  "Your session has expired, please log in again",
+		Status:   http.StatusUnauthorized,
+		Redirect: "/",
+	}
+)
+
+func (e *webError) Error() string {
+	if e.Err != nil {
+		return e.Message + ": " + e.Err.Error()
+	}
+
+	return e.Message
+}
+
+func (e *webError) Unwrap() error {
+	return e.Err
+}
+
+// errSpotify is returned when a request to spotify failed
+func errSpotify(err error, logmsg string) error {
+	return &webError{
+		Err:        err,
+		LogMessage: logmsg,
+		Level:      flashLevelDanger,
+		Message:    msgSpotifyError,
+		Status:     http.StatusBadGateway,
+	}
+}
+
+// errUser is returned when the user gave us something we can not use
+func errUser(message string) error {
+	return &webError{
+		Level:   flashLevelWarning,
+		Message: message,
+		Status:  http.StatusBadRequest,
+	}
+}
+
+// errNotFound is returned when the user asked for something that does not exist
+func errNotFound(message string) error {
+	return &webError{
+		Level:   flashLevelWarning,
+		Message: message,
+		Status:  http.StatusNotFound,
+	}
+}
+
+// errInternal is returned when something went wrong on our side
+func errInternal(err error, logmsg string) error {
+	return &webError{
+		Err:        err,
+		LogMessage: logmsg,
+		Level:      flashLevelDanger,
+		Message:    msgInternal,
+		Status:     http.StatusInternalServerError,
+	}
+}
+
+// toWebError converts any error returned from a handler to a webError
+func toWebError(err error) *webError {
+	var werr *webError
+	if errors.As(err, &werr) {
+		return werr
+	}
+
+	return errInternal(err, "unhandled error").(*webError)
+}
+
+// handleError logs err, adds it as a flash and redirects the user
+func (w *Web) handleError(rw http.ResponseWriter, r *http.Request, err error) {
+	werr := toWebError(err)
+	message := werr.Message
+	if werr.Err != nil {
+		ctxLog(r.Context()).Error().Err(werr.Err).Str("path", r.URL.Path).Msg(werr.LogMessage)
+
+		// Something went wrong on our side, so give the user something to refer to
+		if id := requestID(r.Context()); id != "" {
+			message = fmt.Sprintf("%s (request id: %s)", message, id)
+		}
+	}
+
+	w.addFlash(rw, r, flashMessage{werr.Level, message})
+	if werr.Redirect != "" {
+		http.Redirect(rw, r, werr.Redirect, http.StatusFound)
+		return
+	}
+
+	redirectReferer(rw, r)
+}
+
+type apiErrorResponse struct {
+	Error apiError `json:"error"`
+}
+
+type apiError struct {
+	Status    int    `json:"status"`
+	Message   string `json:"message"`
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// handleAPIError logs err and writes it as a json error response
+func (w *Web) handleAPIError(rw http.ResponseWriter, r *http.Request, err error) {
+	werr := toWebError(err)
+	if werr.Err != nil {
+		ctxLog(r.Context()).Error().Err(werr.Err).Str("path", r.URL.Path).Msg(werr.LogMessage)
+	}
+
+	writeJSON(rw, r, werr.Status, apiErrorResponse{apiError{
+		Status:    werr.Status,
+		Message:   werr.Message,
+		RequestID: requestID(r.Context()),
+	}})
+}
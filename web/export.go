@@ -0,0 +1,277 @@
+package web
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/zmb3/spotify/v2"
+)
+
+const (
+	exportFormatCSV  = "csv"
+	exportFormatJSON = "json"
+	exportFormatM3U  = "m3u8"
+	exportFormatXSPF = "xspf"
+)
+
+var exportContentTypes = map[string]string{
+	exportFormatCSV:  "text/csv",
+	exportFormatJSON: "application/json",
+	exportFormatM3U:  "audio/x-mpegurl",
+	exportFormatXSPF: "application/xspf+xml",
+}
+
+// exportFormats are the formats each kind of top list can be exported as
+var exportFormats = map[string][]string{
+	cacheKindTracks:  {exportFormatCSV, exportFormatJSON, exportFormatM3U, exportFormatXSPF},
+	cacheKindArtists: {exportFormatCSV, exportFormatJSON},
+}
+
+func checkExport(kind, format string) bool {
+	for _, f := range exportFormats[kind] {
+		if f == format {
+			return true
+		}
+	}
+
+	return false
+}
+
+// exportTrack is a single row of an exported top tracks list
+type exportTrack struct {
+	Rank        int      `json:"rank"`
+	Name        string   `json:"name"`
+	Artists     []string `json:"artists"`
+	Album       string   `json:"album"`
+	ReleaseDate string   `json:"release_date"`
+	Popularity  int      `json:"popularity"`
+	URI         string   `json:"uri"`
+	URL         string   `json:"url"`
+	// Duration is the length of the track in milliseconds
+	Duration int `json:"duration_ms"`
+}
+
+// exportArtist is a single row of an exported top artists list
+type exportArtist struct {
+	Rank       int      `json:"rank"`
+	Name       string   `json:"name"`
+	Genres     []string `json:"genres"`
+	Popularity int      `json:"popularity"`
+	URI        string   `json:"uri"`
+	URL        string   `json:"url"`
+}
+
+type exportList struct {
+	User      string      `json:"user"`
+	TimeRange string      `json:"time_range"`
+	Items     interface{} `json:"items"`
+}
+
+// xspfPlaylist is the root element of a XSPF playlist, see https://xspf.org/spec
+type xspfPlaylist struct {
+	XMLName xml.Name    `xml:"http://xspf.org/ns/0/ playlist"`
+	Version int         `xml:"version,attr"`
+	Title   string      `xml:"title"`
+	Creator string      `xml:"creator"`
+	Tracks  []xspfTrack `xml:"trackList>track"`
+}
+
+type xspfTrack struct {
+	Location   string `xml:"location"`
+	Identifier string `xml:"identifier"`
+	Title      string `xml:"title"`
+	Creator    string `xml:"creator"`
+	Album      string `xml:"album"`
+	Duration   int    `xml:"duration"`
+}
+
+func toExportTracks(tracks []spotify.FullTrack) []exportTrack {
+	rows := make([]exportTrack, 0, len(tracks))
+	for i, track := range tracks {
+		var artists []string
+		for _, artist := range track.Artists {
+			artists = append(artists, artist.Name)
+		}
+
+		rows = append(rows, exportTrack{
+			Rank:        i + 1,
+			Name:        track.Name,
+			Artists:     artists,
+			Album:       track.Album.Name,
+			ReleaseDate: track.Album.ReleaseDate,
+			Popularity:  track.Popularity,
+			URI:         string(track.URI),
+			URL:         track.ExternalURLs["spotify"],
+			Duration:    track.Duration,
+		})
+	}
+
+	return rows
+}
+
+func toExportArtists(artists []spotify.FullArtist) []exportArtist {
+	rows := make([]exportArtist, 0, len(artists))
+	for i, artist := range artists {
+		rows = append(rows, exportArtist{
+			Rank:       i + 1,
+			Name:       artist.Name,
+			Genres:     artist.Genres,
+			Popularity: artist.Popularity,
+			URI:        string(artist.URI),
+			URL:        artist.ExternalURLs["spotify"],
+		})
+	}
+
+	return rows
+}
+
+// handleExport sends the top artists or tracks of the user as a
+// download, using the settings of the user
+func (w *Web) handleExport(rw http.ResponseWriter, r *http.Request, rc *requestContext) error {
+	vars := mux.Vars(r)
+	kind, format := vars["kind"], vars["format"]
+	if !checkExport(kind, format) {
+		return errNotFound("That export does not exist")
+	}
+
+	title := fmt.Sprintf("%s Top %d %s - %s", rc.User.DisplayName, rc.Settings.Resultlimit, kind, rc.Settings.TimeLimitFormatter())
+
+	var write func(io.Writer) error
+	switch kind {
+	case cacheKindTracks:
+		toptracks, err := w.topTracks(r.Context(), rc.Client, rc.User.ID, rc.Settings, false)
+		if err != nil {
+			return errSpotify(err, "could not get current user top tracks")
+		}
+		rows := toExportTracks(toptracks)
+
+		switch format {
+		case exportFormatCSV:
+			write = func(out io.Writer) error { return writeTracksCSV(out, rows) }
+		case exportFormatJSON:
+			write = func(out io.Writer) error { return writeExportJSON(out, rc, rows) }
+		case exportFormatM3U:
+			write = func(out io.Writer) error { return writeTracksM3U(out, rows) }
+		case exportFormatXSPF:
+			write = func(out io.Writer) error { return writeTracksXSPF(out, title, rc.User.DisplayName, rows) }
+		}
+	case cacheKindArtists:
+		topartists, err := w.topArtists(r.Context(), rc.Client, rc.User.ID, rc.Settings, false)
+		if err != nil {
+			return errSpotify(err, "could not get current user top artists")
+		}
+		rows := toExportArtists(topartists)
+
+		switch format {
+		case exportFormatCSV:
+			write = func(out io.Writer) error { return writeArtistsCSV(out, rows) }
+		case exportFormatJSON:
+			write = func(out io.Writer) error { return writeExportJSON(out, rc, rows) }
+		}
+	}
+
+	filename := fmt.Sprintf("spotifytop-%s-%s.%s", kind, rc.Settings.Timelimit, format)
+	rw.Header().Set("Content-Type", exportContentTypes[format])
+	rw.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	if err := write(rw); err != nil {
+		ctxLog(r.Context()).Error().Err(err).Str("format", format).Msg("could not write export")
+	}
+
+	return nil
+}
+
+func writeExportJSON(out io.Writer, rc *requestContext, items interface{}) error {
+	enc := json.NewEncoder(out)
+	enc.SetIndent("", "  ")
+	return enc.Encode(exportList{
+		User:      rc.User.DisplayName,
+		TimeRange: rc.Settings.Timelimit,
+		Items:     items,
+	})
+}
+
+func writeTracksCSV(out io.Writer, rows []exportTrack) error {
+	cw := csv.NewWriter(out)
+	cw.Write([]string{"rank", "name", "artists", "album", "release_date", "popularity", "uri"})
+	for _, row := range rows {
+		cw.Write([]string{
+			strconv.Itoa(row.Rank),
+			row.Name,
+			strings.Join(row.Artists, ", "),
+			row.Album,
+			row.ReleaseDate,
+			strconv.Itoa(row.Popularity),
+			row.URI,
+		})
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+func writeArtistsCSV(out io.Writer, rows []exportArtist) error {
+	cw := csv.NewWriter(out)
+	cw.Write([]string{"rank", "name", "genres", "popularity", "uri"})
+	for _, row := range rows {
+		cw.Write([]string{
+			strconv.Itoa(row.Rank),
+			row.Name,
+			strings.Join(row.Genres, ", "),
+			strconv.Itoa(row.Popularity),
+			row.URI,
+		})
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+// writeTracksM3U writes an extended M3U playlist, linking every track on open.spotify.com
+func writeTracksM3U(out io.Writer, rows []exportTrack) error {
+	if _, err := fmt.Fprintln(out, "#EXTM3U"); err != nil {
+		return err
+	}
+
+	for _, row := range rows {
+		location := row.URL
+		if location == "" {
+			location = row.URI
+		}
+
+		_, err := fmt.Fprintf(out, "#EXTINF:%d,%s - %s\n%s\n", row.Duration/1000, strings.Join(row.Artists, ", "), row.Name, location)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func writeTracksXSPF(out io.Writer, title, creator string, rows []exportTrack) error {
+	playlist := xspfPlaylist{Version: 1, Title: title, Creator: creator}
+	for _, row := range rows {
+		playlist.Tracks = append(playlist.Tracks, xspfTrack{
+			Location:   row.URL,
+			Identifier: row.URI,
+			Title:      row.Name,
+			Creator:    strings.Join(row.Artists, ", "),
+			Album:      row.Album,
+			Duration:   row.Duration,
+		})
+	}
+
+	if _, err := io.WriteString(out, xml.Header); err != nil {
+		return err
+	}
+
+	enc := xml.NewEncoder(out)
+	enc.Indent("", "  ")
+	return enc.Encode(playlist)
+}
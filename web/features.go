@@ -0,0 +1,323 @@
+package web
+
+import (
+	"context"
+	"fmt"
+	"html/template"
+	"net/http"
+	"sort"
+
+	"github.com/zmb3/spotify/v2"
+)
+
+const (
+	// featuresBatchSize is the most tracks spotify returns audio features for at once
+	featuresBatchSize = 100
+	// featureBuckets is the number of buckets the features from 0 to 1 are split in
+	featureBuckets = 10
+
+	// Tempos are split in buckets of tempoBucketSize BPM from minTempo to maxTempo
+	tempoBucketSize = 20
+	minTempo        = 60
+	maxTempo        = 200
+
+	featureTempo = "tempo"
+)
+
+var keyNames = []string{"C", "C#/Db", "D", "D#/Eb", "E", "F", "F#/Gb", "G", "G#/Ab", "A", "A#/Bb", "B"}
+
+// featureValues are the features shown in a profile, in order
+var featureValues = []struct {
+	Name  string
+	Value func(*spotify.AudioFeatures) float64
+}{
+	{"danceability", func(f *spotify.AudioFeatures) float64 { return float64(f.Danceability) }},
+	{"energy", func(f *spotify.AudioFeatures) float64 { return float64(f.Energy) }},
+	{"valence", func(f *spotify.AudioFeatures) float64 { return float64(f.Valence) }},
+	{"acousticness", func(f *spotify.AudioFeatures) float64 { return float64(f.Acousticness) }},
+	{featureTempo, func(f *spotify.AudioFeatures) float64 { return float64(f.Tempo) }},
+}
+
+type featureBucket struct {
+	Label string `json:"label"`
+	Count int    `json:"count"`
+}
+
+type featureStat struct {
+	Name         string          `json:"name"`
+	Average      float64         `json:"average"`
+	Distribution []featureBucket `json:"distribution"`
+	Chart        template.HTML   `json:"-"`
+}
+
+// profileRange is the time range a profile of the top tracks was built for,
+// it is shared by the audio features and release year profiles
+type profileRange struct {
+	TimeRange string `json:"time_range"`
+}
+
+// RangeName is the time range of the profile formatted for display
+func (p profileRange) RangeName() string {
+	return Opts{Timelimit: p.TimeRange}.TimeLimitFormatter()
+}
+
+// featureProfile sums up the audio features of the top tracks of a time range
+type featureProfile struct {
+	profileRange
+	// Tracks is the number of tracks spotify has audio features for
+	Tracks   int           `json:"tracks"`
+	Features []featureStat `json:"features"`
+	// Keys counts the tracks per key and mode, the most common first
+	Keys []featureBucket `json:"keys"`
+	// Major is the share of tracks in a major key, from 0 to 1
+	Major    float64       `json:"major"`
+	KeyChart template.HTML `json:"-"`
+}
+
+type featuresData struct {
+	TimeRange string
+	Ranges    []Opts
+	// Profiles has a profile per time range, in the order of ValidTimeLimits
+	Profiles []featureProfile
+	Selected featureProfile
+}
+
+// Display formats the average for display, tempo in BPM and the others from 0 to 1
+func (s featureStat) Display() string {
+	if s.Name == featureTempo {
+		return fmt.Sprintf("%.0f BPM", s.Average)
+	}
+
+	return fmt.Sprintf("%.2f", s.Average)
+}
+
+// MajorPercent is the share of tracks in a major key formatted for display
+func (p featureProfile) MajorPercent() string {
+	return fmt.Sprintf("%.0f%%", p.Major*100)
+}
+
+// audioFeatures fetches the audio features of the tracks in batches, tracks
+// spotify has no features for are left out
+func audioFeatures(ctx context.Context, client *spotify.Client, tracks []spotify.FullTrack) ([]*spotify.AudioFeatures, error) {
+	ids := getTrackIDs(tracks)
+	var features []*spotify.AudioFeatures
+	for start := 0; start < len(ids); start += featuresBatchSize {
+		end := start + featuresBatchSize
+		if end > len(ids) {
+			end = len(ids)
+		}
+
+		batch, err := client.GetAudioFeatures(ctx, ids[start:end]...)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, f := range batch {
+			if f != nil {
+				features = append(features, f)
+			}
+		}
+	}
+
+	return features, nil
+}
+
+// unitDistribution counts the values from 0 to 1 in featureBuckets buckets
+func unitDistribution(values []float64) []featureBucket {
+	buckets := make([]featureBucket, featureBuckets)
+	for i := range buckets {
+		buckets[i].Label = fmt.Sprintf("%.1f - %.1f", float64(i)/featureBuckets, float64(i+1)/featureBuckets)
+	}
+
+	for _, value := range values {
+		// Spotify sends float32, so 0.7 would end up just below its bucket
+		i := int(value*featureBuckets + 1e-6)
+		if i >= featureBuckets {
+			i = featureBuckets - 1
+		} else if i < 0 {
+			i = 0
+		}
+		buckets[i].Count++
+	}
+
+	return buckets
+}
+
+// tempoDistribution counts the tempos in buckets of tempoBucketSize BPM, with
+// a bucket for everything below minTempo and above maxTempo
+func tempoDistribution(values []float64) []featureBucket {
+	buckets := []featureBucket{{Label: fmt.Sprintf("< %d", minTempo)}}
+	for tempo := minTempo; tempo < maxTempo; tempo += tempoBucketSize {
+		buckets = append(buckets, featureBucket{Label: fmt.Sprintf("%d - %d", tempo, tempo+tempoBucketSize)})
+	}
+	buckets = append(buckets, featureBucket{Label: fmt.Sprintf("%d +", maxTempo)})
+
+	for _, value := range values {
+		switch {
+		case value < minTempo:
+			buckets[0].Count++
+		case value >= maxTempo:
+			buckets[len(buckets)-1].Count++
+		default:
+			buckets[1+(int(value)-minTempo)/tempoBucketSize].Count++
+		}
+	}
+
+	return buckets
+}
+
+// keyName returns the name of a pitch class and mode, e.g. "A minor"
+func keyName(key, mode int) string {
+	if key < 0 || key >= len(keyNames) {
+		return "Unknown"
+	}
+
+	if mode == 1 {
+		return keyNames[key] + " major"
+	}
+
+	return keyNames[key] + " minor"
+}
+
+// buildFeatureProfile computes the averages and distributions of the features
+func buildFeatureProfile(timeRange string, features []*spotify.AudioFeatures) featureProfile {
+	profile := featureProfile{profileRange: profileRange{timeRange}, Tracks: len(features)}
+
+	for _, feature := range featureValues {
+		values := make([]float64, 0, len(features))
+		var sum float64
+		for _, f := range features {
+			value := feature.Value(f)
+			values = append(values, value)
+			sum += value
+		}
+
+		stat := featureStat{Name: feature.Name}
+		if len(values) > 0 {
+			stat.Average = sum / float64(len(values))
+		}
+
+		if feature.Name == featureTempo {
+			stat.Distribution = tempoDistribution(values)
+		} else {
+			stat.Distribution = unitDistribution(values)
+		}
+
+		profile.Features = append(profile.Features, stat)
+	}
+
+	counts := make(map[string]int)
+	var major int
+	for _, f := range features {
+		name := keyName(f.Key, f.Mode)
+		if counts[name] == 0 {
+			profile.Keys = append(profile.Keys, featureBucket{Label: name})
+		}
+		counts[name]++
+
+		if f.Mode == 1 {
+			major++
+		}
+	}
+
+	for i := range profile.Keys {
+		profile.Keys[i].Count = counts[profile.Keys[i].Label]
+	}
+
+	sort.SliceStable(profile.Keys, func(i, j int) bool {
+		return profile.Keys[i].Count > profile.Keys[j].Count
+	})
+
+	if len(features) > 0 {
+		profile.Major = float64(major) / float64(len(features))
+	}
+
+	return profile
+}
+
+func bucketBars(buckets []featureBucket) []chartBar {
+	bars := make([]chartBar, 0, len(buckets))
+	for _, bucket := range buckets {
+		bars = append(bars, chartBar{Label: bucket.Label, Value: float64(bucket.Count)})
+	}
+
+	return bars
+}
+
+// renderCharts renders the distributions of the profile as svg charts
+func (p *featureProfile) renderCharts() {
+	for i := range p.Features {
+		p.Features[i].Chart = barChart(bucketBars(p.Features[i].Distribution))
+	}
+
+	p.KeyChart = barChart(bucketBars(p.Keys))
+}
+
+// tracksFeatureProfile fetches the audio features of the tracks and sums them up
+func tracksFeatureProfile(ctx context.Context, client *spotify.Client, timeRange string, tracks []spotify.FullTrack) (featureProfile, error) {
+	features, err := audioFeatures(ctx, client, tracks)
+	if err != nil {
+		return featureProfile{}, err
+	}
+
+	return buildFeatureProfile(timeRange, features), nil
+}
+
+// handleFeatures compares the audio features of the top tracks in every time
+// range, and shows the distributions of the selected one
+func (w *Web) handleFeatures(rw http.ResponseWriter, r *http.Request, rc *requestContext) error {
+	timeRange := r.URL.Query().Get("range")
+	if timeRange == "" {
+		timeRange = rc.Settings.Timelimit
+	}
+
+	if !checkTimelimit(timeRange) {
+		return errUser(fmt.Sprintf("Time range has to be one of %v", ValidTimeLimits))
+	}
+
+	lists, err := w.topTracksAllRanges(r.Context(), rc)
+	if err != nil {
+		return errSpotify(err, "could not get current user top tracks")
+	}
+
+	profiles := make([]featureProfile, len(lists))
+	err = fetchRanges(rc.Settings, func(i int, settings Opts) error {
+		var err error
+		profiles[i], err = tracksFeatureProfile(r.Context(), rc.Client, settings.Timelimit, lists[i])
+		return err
+	})
+	if err != nil {
+		return errSpotify(err, "could not get audio features")
+	}
+
+	data := featuresData{TimeRange: timeRange, Ranges: timeRanges(), Profiles: profiles}
+	for _, profile := range profiles {
+		if profile.TimeRange == timeRange {
+			data.Selected = profile
+			data.Selected.renderCharts()
+		}
+	}
+
+	w.templateExec(rw, r, "features", rc.tmplData(data))
+	return nil
+}
+
+func (w *Web) handleAPIFeatures(rw http.ResponseWriter, r *http.Request, rc *requestContext) error {
+	query, err := parseTopQuery(r, rc.Settings)
+	if err != nil {
+		return err
+	}
+
+	tracks, err := w.topTracks(r.Context(), rc.Client, rc.User.ID, Opts{Timelimit: query.Timelimit, Resultlimit: query.Limit}, false)
+	if err != nil {
+		return errSpotify(err, "could not get current user top tracks")
+	}
+
+	profile, err := tracksFeatureProfile(r.Context(), rc.Client, query.Timelimit, tracks)
+	if err != nil {
+		return errSpotify(err, "could not get audio features")
+	}
+
+	writeJSON(rw, r, http.StatusOK, profile)
+	return nil
+}
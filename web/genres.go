@@ -0,0 +1,185 @@
+package web
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/zmb3/spotify/v2"
+)
+
+const (
+	defaultGenreFamiliesPath = "web/genres.json"
+	// genreOtherFamily holds the genres not matched by any family
+	genreOtherFamily = "Other"
+	// genreChartBars is the number of genres shown in the chart
+	genreChartBars = 15
+)
+
+// genreFamily groups micro-genres, a genre belongs to the first family with a
+// match that is the genre or a sequence of whole words in it
+type genreFamily struct {
+	Name  string   `json:"name"`
+	Match []string `json:"match"`
+}
+
+type genreFamiliesFile struct {
+	Families []genreFamily `json:"families"`
+}
+
+type genreStat struct {
+	Rank   int
+	Name   string
+	Family string
+	// Score is the sum of the rank weights of the artists with the genre
+	Score   float64
+	Share   float64
+	Artists []string
+}
+
+type genreFamilyStat struct {
+	Name   string
+	Score  float64
+	Share  float64
+	Genres []string
+}
+
+type genresData struct {
+	Genres      []genreStat
+	Families    []genreFamilyStat
+	GenreChart  template.HTML
+	FamilyChart template.HTML
+}
+
+// loadGenreFamilies reads the mapping of micro-genres to their parent families
+func loadGenreFamilies(path string) ([]genreFamily, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var file genreFamiliesFile
+	if err := json.Unmarshal(raw, &file); err != nil {
+		return nil, err
+	}
+
+	for i, family := range file.Families {
+		if family.Name == "" {
+			return nil, fmt.Errorf("family %d has no name", i)
+		}
+	}
+
+	return file.Families, nil
+}
+
+// genreFamilyOf returns the family the genre belongs to
+func genreFamilyOf(families []genreFamily, genre string) string {
+	padded := " " + strings.ToLower(genre) + " "
+	for _, family := range families {
+		for _, match := range family.Match {
+			if strings.Contains(padded, " "+strings.ToLower(match)+" ") {
+				return family.Name
+			}
+		}
+	}
+
+	return genreOtherFamily
+}
+
+// rankWeight weights the artist at index i of n, the top artist counts
+// fully and the last one 1/n
+func rankWeight(i, n int) float64 {
+	return float64(n-i) / float64(n)
+}
+
+// aggregateGenres sums the rank weights of the artists per genre and per
+// family, both sorted by score
+func aggregateGenres(artists []spotify.FullArtist, families []genreFamily) ([]genreStat, []genreFamilyStat) {
+	byGenre := make(map[string]*genreStat)
+	var genres []*genreStat
+	var total float64
+	for i, artist := range artists {
+		weight := rankWeight(i, len(artists))
+		for _, genre := range artist.Genres {
+			stat, ok := byGenre[genre]
+			if !ok {
+				stat = &genreStat{Name: genre, Family: genreFamilyOf(families, genre)}
+				byGenre[genre] = stat
+				genres = append(genres, stat)
+			}
+
+			stat.Score += weight
+			stat.Artists = append(stat.Artists, artist.Name)
+			total += weight
+		}
+	}
+
+	byFamily := make(map[string]*genreFamilyStat)
+	var familyStats []*genreFamilyStat
+	for _, genre := range genres {
+		genre.Share = genre.Score / total * 100
+
+		family, ok := byFamily[genre.Family]
+		if !ok {
+			family = &genreFamilyStat{Name: genre.Family}
+			byFamily[genre.Family] = family
+			familyStats = append(familyStats, family)
+		}
+
+		family.Score += genre.Score
+		family.Share += genre.Share
+		family.Genres = append(family.Genres, genre.Name)
+	}
+
+	sort.SliceStable(genres, func(i, j int) bool {
+		return genres[i].Score > genres[j].Score
+	})
+
+	sort.SliceStable(familyStats, func(i, j int) bool {
+		return familyStats[i].Score > familyStats[j].Score
+	})
+
+	genreResult := make([]genreStat, len(genres))
+	for i, genre := range genres {
+		genre.Rank = i + 1
+		genreResult[i] = *genre
+	}
+
+	familyResult := make([]genreFamilyStat, len(familyStats))
+	for i, family := range familyStats {
+		familyResult[i] = *family
+	}
+
+	return genreResult, familyResult
+}
+
+// handleGenres shows which genres the top artists of the user belong to,
+// weighted by the rank of the artists
+func (w *Web) handleGenres(rw http.ResponseWriter, r *http.Request, rc *requestContext) error {
+	artists, err := w.topArtists(r.Context(), rc.Client, rc.User.ID, rc.Settings, r.URL.Query().Get("refresh") != "")
+	if err != nil {
+		return errSpotify(err, "could not get current user top artists")
+	}
+
+	genres, families := aggregateGenres(artists, w.genreFamilies)
+	data := genresData{Genres: genres, Families: families}
+
+	var genreBars []chartBar
+	for i := 0; i < len(genres) && i < genreChartBars; i++ {
+		genreBars = append(genreBars, chartBar{genres[i].Name, genres[i].Share, fmt.Sprintf("%.1f%%", genres[i].Share)})
+	}
+	data.GenreChart = barChart(genreBars)
+
+	var familyBars []chartBar
+	for _, family := range families {
+		familyBars = append(familyBars, chartBar{family.Name, family.Share, fmt.Sprintf("%.1f%%", family.Share)})
+	}
+	data.FamilyChart = barChart(familyBars)
+
+	w.templateExec(rw, r, "genres", rc.tmplData(data))
+	return nil
+}
@@ -0,0 +1,104 @@
+package web
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"time"
+)
+
+const (
+	readyCheckTimeout  = 2 * time.Second
+	spotifyAccountHost = "accounts.spotify.com"
+)
+
+type checkResult struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+type readyResponse struct {
+	Status string                 `json:"status"`
+	Checks map[string]checkResult `json:"checks"`
+}
+
+// handleHealthz reports that the process is alive
+func (w *Web) handleHealthz(rw http.ResponseWriter, r *http.Request) {
+	writeJSON(rw, r, http.StatusOK, checkResult{Status: "ok"})
+}
+
+// handleReadyz reports whether the server is able to serve requests
+func (w *Web) handleReadyz(rw http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), readyCheckTimeout)
+	defer cancel()
+
+	checks := map[string]func(context.Context) error{
+		"templates": w.checkTemplates,
+		"sessions":  w.checkSessions,
+		"storage":   w.checkStorage,
+	}
+	if w.ReadyCheckSpotify {
+		checks["spotify"] = checkSpotify
+	}
+
+	resp := readyResponse{Status: "ok", Checks: make(map[string]checkResult)}
+	status := http.StatusOK
+	for name, check := range checks {
+		if err := check(ctx); err != nil {
+			ctxLog(r.Context()).Warn().Err(err).Str("check", name).Msg("readiness check failed")
+			resp.Checks[name] = checkResult{Status: "fail", Error: err.Error()}
+			resp.Status = "fail"
+			status = http.StatusServiceUnavailable
+			continue
+		}
+
+		resp.Checks[name] = checkResult{Status: "ok"}
+	}
+
+	writeJSON(rw, r, status, resp)
+}
+
+func (w *Web) checkTemplates(ctx context.Context) error {
+	for _, name := range templateNames {
+		if _, ok := w.Templates[name]; !ok {
+			return errors.New("template " + name + " is not parsed")
+		}
+	}
+
+	return nil
+}
+
+// checkSessions makes sure the session registry is not stuck behind a lock
+func (w *Web) checkSessions(ctx context.Context) error {
+	if w.Cookies == nil || w.Clients == nil {
+		return errors.New("session store is not initialized")
+	}
+
+	done := make(chan struct{})
+	go func() {
+		w.clientsLock.RLock()
+		w.clientsLock.RUnlock()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return errors.New("session store is not responding")
+	}
+}
+
+func (w *Web) checkStorage(ctx context.Context) error {
+	if w.Storage == nil {
+		return errors.New("storage is not initialized")
+	}
+
+	return w.Storage.Ping()
+}
+
+func checkSpotify(ctx context.Context) error {
+	_, err := net.DefaultResolver.LookupHost(ctx, spotifyAccountHost)
+	return err
+}
@@ -1,10 +1,13 @@
 package web
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/rs/zerolog/log"
 	"github.com/zmb3/spotify/v2"
@@ -29,6 +32,8 @@ type TmplData struct {
 	Settings Opts
 	User     spotify.User
 	LoggedIn bool
+	// Pagination is set on pages showing Result split over several pages
+	Pagination *pagination
 }
 
 type Opts struct {
@@ -36,6 +41,11 @@ type Opts struct {
 	Resultlimit int
 }
 
+// MaxResultlimit is the highest number of results that can be selected
+func (o Opts) MaxResultlimit() int {
+	return maxResultLimit
+}
+
 func (fl flashLevel) String() string {
 	switch fl {
 	case flashLevelInfo:
@@ -73,13 +83,13 @@ type flashMessage struct {
 func (w *Web) addFlash(rw http.ResponseWriter, r *http.Request, message flashMessage) {
 	session, err := w.Cookies.Get(r, cookieKeyFlashMessage)
 	if err != nil {
-		log.Error().Err(err).Msg("could not get flash cookie")
+		ctxLog(r.Context()).Error().Err(err).Msg("could not get flash cookie")
 		return
 	}
 
 	session.AddFlash(message, cookieKeyFlashMessage)
 	if err := session.Save(r, rw); err != nil {
-		log.Error().Err(err).Msg("could not save session")
+		ctxLog(r.Context()).Error().Err(err).Msg("could not save session")
 		return
 	}
 }
@@ -87,7 +97,7 @@ func (w *Web) addFlash(rw http.ResponseWriter, r *http.Request, message flashMes
 func (w *Web) getFlash(rw http.ResponseWriter, r *http.Request) []flashMessage {
 	session, err := w.Cookies.Get(r, cookieKeyFlashMessage)
 	if err != nil {
-		log.Error().Err(err).Msg("could not get flash cookie")
+		ctxLog(r.Context()).Error().Err(err).Msg("could not get flash cookie")
 		return nil
 	}
 
@@ -104,7 +114,7 @@ func (w *Web) getFlash(rw http.ResponseWriter, r *http.Request) []flashMessage {
 	}
 
 	if err := session.Save(r, rw); err != nil {
-		log.Error().Err(err).Msg("could not save session")
+		ctxLog(r.Context()).Error().Err(err).Msg("could not save session")
 		return nil
 	}
 
@@ -113,11 +123,17 @@ func (w *Web) getFlash(rw http.ResponseWriter, r *http.Request) []flashMessage {
 
 func (w *Web) cookieSetSettings(rw http.ResponseWriter, r *http.Request, settings Opts) {
 	if !checkTimelimit(settings.Timelimit) {
-		log.Debug().Interface("timelimit", settings.Timelimit).Msg("unsupported timelimit, using default")
+		ctxLog(r.Context()).Debug().Interface("timelimit", settings.Timelimit).Msg("unsupported timelimit, using default")
 		w.addFlash(rw, r, flashMessage{flashLevelWarning, "You have to select a valid time range"})
 		settings.Timelimit = defaultTimeLimit
 	}
 
+	if !checkResultlimit(settings.Resultlimit) {
+		ctxLog(r.Context()).Debug().Int("resultlimit", settings.Resultlimit).Msg("unsupported resultlimit, using default")
+		w.addFlash(rw, r, flashMessage{flashLevelWarning, "You have to select a valid number of results"})
+		settings.Resultlimit = defaultResultLimit
+	}
+
 	cookie := http.Cookie{Name: "settings", Value: settings.Timelimit + "," + strconv.Itoa(settings.Resultlimit), MaxAge: 3600}
 	http.SetCookie(rw, &cookie)
 }
@@ -154,15 +170,20 @@ func (w *Web) cookieGetSettings(rw http.ResponseWriter, r *http.Request) Opts {
 			return settings
 		}
 
-		log.Error().Err(err).Msg("could not get settings, using defaults")
+		ctxLog(r.Context()).Error().Err(err).Msg("could not get settings, using defaults")
 		return settings
 	}
 
 	cookiesettings := cookieSettingSplitter(cookie.Value)
+	if len(cookiesettings) != 2 || !checkTimelimit(cookiesettings[0]) {
+		ctxLog(r.Context()).Error().Str("settings", cookie.Value).Msg("malformed settings cookie, using defaults")
+		return settings
+	}
+
 	settings.Timelimit = cookiesettings[0]
 	settings.Resultlimit, err = strconv.Atoi(cookiesettings[1])
-	if err != nil {
-		log.Error().Err(err).Msgf("could not convert %s to int, using default result limit", cookiesettings[1])
+	if err != nil || !checkResultlimit(settings.Resultlimit) {
+		ctxLog(r.Context()).Error().Err(err).Msgf("could not use %s as result limit, using default result limit", cookiesettings[1])
 		settings.Resultlimit = defaultResultLimit
 		return settings
 	}
@@ -175,17 +196,39 @@ func (w *Web) createClient(rw http.ResponseWriter, r *http.Request, state string
 		return ErrNoAuthClient
 	}
 
-	if _, ok := w.Clients[state]; ok {
+	if _, ok := w.sessionGet(state); ok {
 		return nil
 	}
 
 	token, err := w.Auth.Token(r.Context(), state, r)
 	if err != nil {
-		log.Error().Err(err).Msg("could not get token")
+		ctxLog(r.Context()).Error().Err(err).Msg("could not get token")
+		return err
+	}
+
+	user, err := w.newSpotifyClient(r.Context(), token, "").CurrentUser(r.Context())
+	if err != nil {
+		ctxLog(r.Context()).Error().Err(err).Msg("could not get user")
+		return err
+	}
+
+	// Store the token, so the api can be used with api tokens
+	// after the browser session has ended
+	if err := w.Storage.SpotifyTokenSave(user.ID, token); err != nil {
+		ctxLog(r.Context()).Error().Err(err).Msg("could not save spotify token")
 		return err
 	}
 
-	w.Clients[state] = spotify.New(w.Auth.Client(r.Context(), token))
+	// Sessions built from the old token would keep using it until it is revoked
+	w.storedSessionSet(user.ID, token)
+
+	// The client outlives the request, so it can not use the request context
+	// for refreshing the token
+	w.sessionSet(state, &Session{
+		Client:      w.newSpotifyClient(context.Background(), token, user.ID),
+		User:        user,
+		UserFetched: time.Now(),
+	})
 	return nil
 }
 
@@ -204,6 +247,18 @@ func checkTimelimit(timelimit string) bool {
 	return false
 }
 
+func writeJSON(rw http.ResponseWriter, r *http.Request, status int, v interface{}) {
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(status)
+	if err := json.NewEncoder(rw).Encode(v); err != nil {
+		ctxLog(r.Context()).Error().Err(err).Msg("could not write json response")
+	}
+}
+
+func checkResultlimit(resultlimit int) bool {
+	return resultlimit >= 1 && resultlimit <= maxResultLimit
+}
+
 func redirectReferer(rw http.ResponseWriter, r *http.Request) {
 	ref := r.Header.Get("Referer")
 	if ref == "" {
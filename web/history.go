@@ -0,0 +1,148 @@
+package web
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/mdanie17/spotifytop/storage"
+	"github.com/rs/zerolog/log"
+)
+
+const snapshotPruneInterval = 24 * time.Hour
+
+type historyData struct {
+	Kind      string
+	TimeRange string
+	// Ranges are the time ranges that can be browsed
+	Ranges []Opts
+	// Dates are the dates with a snapshot, newest first
+	Dates    []string
+	Date     string
+	Snapshot *storage.Snapshot
+}
+
+// timeRanges returns settings for every valid time range, for listing them on a page
+func timeRanges() []Opts {
+	ranges := make([]Opts, 0, len(ValidTimeLimits))
+	for _, timelimit := range ValidTimeLimits {
+		ranges = append(ranges, Opts{Timelimit: timelimit})
+	}
+
+	return ranges
+}
+
+func toSnapshotItems(items []rankedItem) []storage.SnapshotItem {
+	snapshotItems := make([]storage.SnapshotItem, 0, len(items))
+	for i, item := range items {
+		snapshotItems = append(snapshotItems, storage.SnapshotItem{
+			Rank:     i + 1,
+			ID:       item.ID,
+			Name:     item.Name,
+			Subtitle: item.Subtitle,
+			Image:    item.Image,
+		})
+	}
+
+	return snapshotItems
+}
+
+// recordSnapshot stores the top list of the user as todays snapshot. If
+// a longer list was already stored today it is kept instead.
+func (w *Web) recordSnapshot(ctx context.Context, userID, kind, timelimit string, items []rankedItem) {
+	now := time.Now()
+	date := now.Format(storage.SnapshotDateLayout)
+	logger := ctxLog(ctx).With().Str("kind", kind).Str("timelimit", timelimit).Logger()
+
+	existing, err := w.Storage.SnapshotGet(userID, kind, timelimit, date)
+	if err != nil && !errors.Is(err, storage.ErrNotFound) {
+		logger.Error().Err(err).Msg("could not get snapshot")
+		return
+	}
+
+	if len(existing.Items) > len(items) {
+		return
+	}
+
+	err = w.Storage.SnapshotSave(storage.Snapshot{
+		UserID:    userID,
+		Kind:      kind,
+		TimeRange: timelimit,
+		Date:      date,
+		Created:   now,
+		Items:     toSnapshotItems(items),
+	})
+	if err != nil {
+		logger.Error().Err(err).Msg("could not save snapshot")
+		return
+	}
+
+	logger.Debug().Msg("saved snapshot")
+}
+
+// pruneSnapshots deletes snapshots older than SnapshotRetention,
+// once on start and then every snapshotPruneInterval
+func (w *Web) pruneSnapshots() {
+	ticker := time.NewTicker(snapshotPruneInterval)
+	defer ticker.Stop()
+
+	for {
+		deleted, err := w.Storage.SnapshotPrune(time.Now().Add(-w.SnapshotRetention))
+		if err != nil {
+			log.Error().Err(err).Msg("could not prune snapshots")
+		} else {
+			log.Info().Int("deleted", deleted).Dur("retention", w.SnapshotRetention).Msg("pruned snapshots")
+		}
+
+		<-ticker.C
+	}
+}
+
+// handleHistory shows the stored snapshots of a top list
+func (w *Web) handleHistory(rw http.ResponseWriter, r *http.Request, rc *requestContext) error {
+	query := r.URL.Query()
+	data := historyData{
+		Kind:      query.Get("kind"),
+		TimeRange: query.Get("range"),
+		Date:      query.Get("date"),
+	}
+
+	if data.Kind != cacheKindArtists {
+		data.Kind = cacheKindTracks
+	}
+
+	if !checkTimelimit(data.TimeRange) {
+		data.TimeRange = rc.Settings.Timelimit
+	}
+
+	if _, err := time.Parse(storage.SnapshotDateLayout, data.Date); data.Date != "" && err != nil {
+		return errUser(fmt.Sprintf("Dates have to be like %s", storage.SnapshotDateLayout))
+	}
+
+	data.Ranges = timeRanges()
+
+	dates, err := w.Storage.SnapshotDates(rc.User.ID, data.Kind, data.TimeRange)
+	if err != nil {
+		return errInternal(err, "could not get snapshot dates")
+	}
+	data.Dates = dates
+
+	if data.Date == "" && len(dates) > 0 {
+		data.Date = dates[0]
+	}
+
+	if data.Date != "" {
+		snapshot, err := w.Storage.SnapshotGet(rc.User.ID, data.Kind, data.TimeRange, data.Date)
+		switch {
+		case err == nil:
+			data.Snapshot = &snapshot
+		case !errors.Is(err, storage.ErrNotFound):
+			return errInternal(err, "could not get snapshot")
+		}
+	}
+
+	w.templateExec(rw, r, "history", rc.tmplData(data))
+	return nil
+}
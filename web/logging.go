@@ -0,0 +1,124 @@
+package web
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"time"
+
+	"github.com/aidarkhanov/nanoid"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+const headerRequestID = "X-Request-ID"
+
+// accessLogExclude are paths that are not access logged,
+// as they are polled by load balancers and supervisors
+var accessLogExclude = map[string]bool{
+	"/healthz": true,
+	"/readyz":  true,
+}
+
+// requestInfo is filled in while a request is handled,
+// and logged when the request is done
+type requestInfo struct {
+	ID string
+	// User is the anonymized id of the logged in user, if any
+	User string
+}
+
+// statusRecorder remembers the status code and
+// number of bytes written to a http.ResponseWriter
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (sr *statusRecorder) WriteHeader(status int) {
+	if sr.status == 0 {
+		sr.status = status
+	}
+
+	sr.ResponseWriter.WriteHeader(status)
+}
+
+func (sr *statusRecorder) Write(b []byte) (int, error) {
+	if sr.status == 0 {
+		sr.status = http.StatusOK
+	}
+
+	n, err := sr.ResponseWriter.Write(b)
+	sr.bytes += n
+	return n, err
+}
+
+// logRequests gives every request an id and a logger carrying it,
+// and writes an access log line when the request is done
+func (w *Web) logRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		info := &requestInfo{ID: nanoid.New()}
+		rw.Header().Set(headerRequestID, info.ID)
+
+		logger := log.With().Str("request_id", info.ID).Logger()
+		ctx := logger.WithContext(r.Context())
+		ctx = context.WithValue(ctx, contextKeyRequestInfo, info)
+
+		rec := &statusRecorder{ResponseWriter: rw}
+		next.ServeHTTP(rec, r.WithContext(ctx))
+
+		if accessLogExclude[r.URL.Path] {
+			return
+		}
+
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+
+		logger.Info().
+			Str("method", r.Method).
+			Str("path", r.URL.Path).
+			Int("status", rec.status).
+			Dur("latency", time.Since(start)).
+			Int("bytes", rec.bytes).
+			Str("user", info.User).
+			Msg("request")
+	})
+}
+
+// getRequestInfo returns the requestInfo set by logRequests, or nil
+func getRequestInfo(ctx context.Context) *requestInfo {
+	info, _ := ctx.Value(contextKeyRequestInfo).(*requestInfo)
+	return info
+}
+
+// requestID returns the id of the request, or an empty string
+func requestID(ctx context.Context) string {
+	if info := getRequestInfo(ctx); info != nil {
+		return info.ID
+	}
+
+	return ""
+}
+
+// ctxLog returns the logger of the request, falling back
+// to the global logger outside of requests
+func ctxLog(ctx context.Context) *zerolog.Logger {
+	if logger := zerolog.Ctx(ctx); logger.GetLevel() != zerolog.Disabled {
+		return logger
+	}
+
+	return &log.Logger
+}
+
+// anonymizeUserID returns an id that identifies the user in logs
+// without revealing their spotify id
+func (w *Web) anonymizeUserID(userID string) string {
+	mac := hmac.New(sha256.New, w.CookieKey)
+	mac.Write([]byte(userID))
+	return hex.EncodeToString(mac.Sum(nil))[:12]
+}
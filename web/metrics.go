@@ -0,0 +1,184 @@
+package web
+
+import (
+	"net/http"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const metricsNamespace = "spotifytop"
+
+// metrics holds all the metrics exposed on /metrics
+type metrics struct {
+	registry *prometheus.Registry
+
+	httpRequests        *prometheus.CounterVec
+	httpRequestDuration *prometheus.HistogramVec
+
+	spotifyRequests        *prometheus.CounterVec
+	spotifyRequestDuration *prometheus.HistogramVec
+	spotifyErrors          *prometheus.CounterVec
+
+	templateErrors     *prometheus.CounterVec
+	playlistsCreated   prometheus.Counter
+	scheduledSnapshots *prometheus.CounterVec
+}
+
+// spotifyEndpoint maps a request to the spotify api to
+// the name of the spotify.Client method making it
+type spotifyEndpoint struct {
+	Method string
+	Path   *regexp.Regexp
+	Name   string
+}
+
+var spotifyEndpoints = []spotifyEndpoint{
+	{http.MethodGet, regexp.MustCompile(`^/v1/me$`), "CurrentUser"},
+	{http.MethodGet, regexp.MustCompile(`^/v1/me/top/artists$`), "CurrentUsersTopArtists"},
+	{http.MethodGet, regexp.MustCompile(`^/v1/me/top/tracks$`), "CurrentUsersTopTracks"},
+	{http.MethodPost, regexp.MustCompile(`^/v1/users/[^/]+/playlists$`), "CreatePlaylistForUser"},
+	{http.MethodGet, regexp.MustCompile(`^/v1/playlists/[^/]+$`), "GetPlaylist"},
+	{http.MethodPut, regexp.MustCompile(`^/v1/playlists/[^/]+$`), "ChangePlaylist"},
+	{http.MethodGet, regexp.MustCompile(`^/v1/playlists/[^/]+/tracks$`), "GetPlaylistTracks"},
+	{http.MethodPost, regexp.MustCompile(`^/v1/playlists/[^/]+/tracks$`), "AddTracksToPlaylist"},
+	{http.MethodPut, regexp.MustCompile(`^/v1/playlists/[^/]+/tracks$`), "ReplacePlaylistTracks"},
+	{http.MethodDelete, regexp.MustCompile(`^/v1/playlists/[^/]+/tracks$`), "RemoveTracksFromPlaylist"},
+	{http.MethodGet, regexp.MustCompile(`^/v1/playlists/[^/]+/followers/contains$`), "UserFollowsPlaylist"},
+	{http.MethodPut, regexp.MustCompile(`^/v1/playlists/[^/]+/images$`), "SetPlaylistImage"},
+	{http.MethodGet, regexp.MustCompile(`^/v1/recommendations$`), "GetRecommendations"},
+	{http.MethodGet, regexp.MustCompile(`^/v1/recommendations/available-genre-seeds$`), "GetAvailableGenreSeeds"},
+	{http.MethodGet, regexp.MustCompile(`^/v1/audio-features$`), "GetAudioFeatures"},
+}
+
+func newMetrics(w *Web) *metrics {
+	m := &metrics{
+		registry: prometheus.NewRegistry(),
+		httpRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "http_requests_total",
+			Help:      "Number of handled http requests per route.",
+		}, []string{"route", "method", "status"}),
+		httpRequestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "http_request_duration_seconds",
+			Help:      "Latency of handled http requests per route.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"route", "method"}),
+		spotifyRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "spotify_requests_total",
+			Help:      "Number of requests made to the spotify api per endpoint.",
+		}, []string{"endpoint"}),
+		spotifyRequestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "spotify_request_duration_seconds",
+			Help:      "Latency of requests made to the spotify api per endpoint.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"endpoint"}),
+		spotifyErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "spotify_errors_total",
+			Help:      "Number of failed requests to the spotify api per endpoint.",
+		}, []string{"endpoint"}),
+		templateErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "template_render_errors_total",
+			Help:      "Number of templates that failed to render.",
+		}, []string{"template"}),
+		playlistsCreated: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "playlists_created_total",
+			Help:      "Number of playlists created.",
+		}),
+		scheduledSnapshots: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "scheduled_snapshots_total",
+			Help:      "Number of users snapshotted by the scheduler per result.",
+		}, []string{"result"}),
+	}
+
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.httpRequests,
+		m.httpRequestDuration,
+		m.spotifyRequests,
+		m.spotifyRequestDuration,
+		m.spotifyErrors,
+		m.templateErrors,
+		m.playlistsCreated,
+		m.scheduledSnapshots,
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "active_sessions",
+			Help:      "Number of logged in users.",
+		}, func() float64 {
+			w.clientsLock.RLock()
+			defer w.clientsLock.RUnlock()
+			return float64(len(w.Clients))
+		}),
+	)
+
+	return m
+}
+
+func (m *metrics) handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// measureRequests records the count and latency of requests per mux route
+func (m *metrics) measureRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: rw}
+		next.ServeHTTP(rec, r)
+
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+
+		route := "unknown"
+		if current := mux.CurrentRoute(r); current != nil {
+			if tmpl, err := current.GetPathTemplate(); err == nil {
+				route = tmpl
+			}
+		}
+
+		m.httpRequests.WithLabelValues(route, r.Method, strconv.Itoa(rec.status)).Inc()
+		m.httpRequestDuration.WithLabelValues(route, r.Method).Observe(time.Since(start).Seconds())
+	})
+}
+
+// spotifyTransport records the count, latency and errors
+// of requests made to the spotify api per endpoint
+type spotifyTransport struct {
+	metrics *metrics
+	next    http.RoundTripper
+}
+
+func (t *spotifyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	endpoint := "other"
+	for _, e := range spotifyEndpoints {
+		if req.Method == e.Method && e.Path.MatchString(req.URL.Path) {
+			endpoint = e.Name
+			break
+		}
+	}
+
+	start := time.Now()
+	resp, err := t.next.RoundTrip(req)
+
+	t.metrics.spotifyRequests.WithLabelValues(endpoint).Inc()
+	t.metrics.spotifyRequestDuration.WithLabelValues(endpoint).Observe(time.Since(start).Seconds())
+	if err != nil || resp.StatusCode >= http.StatusBadRequest {
+		t.metrics.spotifyErrors.WithLabelValues(endpoint).Inc()
+	}
+
+	return resp, err
+}
@@ -0,0 +1,126 @@
+package web
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/zmb3/spotify/v2"
+)
+
+type contextKey uint8
+
+const (
+	contextKeyRequest contextKey = iota
+	contextKeyRequestInfo
+)
+
+// requestContext holds everything a handler needs to know about the logged in user
+type requestContext struct {
+	State    string
+	Session  *Session
+	Client   *spotify.Client
+	User     *spotify.PrivateUser
+	Settings Opts
+}
+
+// loginHandler handles a request from a logged in user,
+// any returned error is shown to the user as a flash message
+type loginHandler func(rw http.ResponseWriter, r *http.Request, rc *requestContext) error
+
+// tmplData returns the template data for a page showing result
+func (rc *requestContext) tmplData(result interface{}) TmplData {
+	return TmplData{
+		Result:   result,
+		Settings: rc.Settings,
+		User:     rc.User.User,
+		LoggedIn: true,
+	}
+}
+
+// getRequestContext returns the requestContext injected by requireLogin, or nil
+func getRequestContext(r *http.Request) *requestContext {
+	rc, _ := r.Context().Value(contextKeyRequest).(*requestContext)
+	return rc
+}
+
+// loadRequestContext looks up the session of the user making the request
+func (w *Web) loadRequestContext(rw http.ResponseWriter, r *http.Request) (*requestContext, error) {
+	state, err := w.cookieGetState(rw, r)
+	if err != nil {
+		return nil, errNotLoggedIn
+	}
+
+	session, ok := w.sessionGet(state)
+	if !ok {
+		return nil, errSessionExpired
+	}
+
+	user, err := w.sessionUser(r.Context(), session, false)
+	if err != nil {
+		return nil, errSpotify(err, "could not get user")
+	}
+
+	return &requestContext{
+		State:    state,
+		Session:  session,
+		Client:   session.Client,
+		User:     user,
+		Settings: w.cookieGetSettings(rw, r),
+	}, nil
+}
+
+// optionalTmplData returns the template data for a page showing result,
+// that can be viewed both logged in and logged out
+func (w *Web) optionalTmplData(rw http.ResponseWriter, r *http.Request, result interface{}) (TmplData, error) {
+	rc, err := w.loadRequestContext(rw, r)
+	if err == errNotLoggedIn || err == errSessionExpired {
+		return TmplData{Result: result, Settings: w.cookieGetSettings(rw, r), LoggedIn: false}, nil
+	} else if err != nil {
+		return TmplData{}, err
+	}
+
+	return rc.tmplData(result), nil
+}
+
+// requireLogin only lets logged in users through to h, and
+// turns errors returned from h into flash messages
+func (w *Web) requireLogin(h loginHandler) http.HandlerFunc {
+	return w.withLogin(w.loadRequestContext, h, w.handleError)
+}
+
+// requireAPILogin only lets users logged in, or using an api token with
+// scope, through to h, and turns errors returned from h into json error responses
+func (w *Web) requireAPILogin(scope string, h loginHandler) http.HandlerFunc {
+	load := func(rw http.ResponseWriter, r *http.Request) (*requestContext, error) {
+		if token, ok := bearerToken(r); ok {
+			return w.loadTokenRequestContext(r.Context(), token, scope)
+		}
+
+		return w.loadRequestContext(rw, r)
+	}
+
+	return w.withLogin(load, h, w.handleAPIError)
+}
+
+func (w *Web) withLogin(
+	load func(http.ResponseWriter, *http.Request) (*requestContext, error),
+	h loginHandler,
+	onError func(http.ResponseWriter, *http.Request, error),
+) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		rc, err := load(rw, r)
+		if err != nil {
+			onError(rw, r, err)
+			return
+		}
+
+		if info := getRequestInfo(r.Context()); info != nil {
+			info.User = w.anonymizeUserID(rc.User.ID)
+		}
+
+		r = r.WithContext(context.WithValue(r.Context(), contextKeyRequest, rc))
+		if err := h(rw, r, rc); err != nil {
+			onError(rw, r, err)
+		}
+	}
+}
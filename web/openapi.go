@@ -0,0 +1,156 @@
+package web
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	apiSpecPath   = "web/openapi.json"
+	apiPathPrefix = "/api/"
+)
+
+// apiSpec is the part of the OpenAPI document needed
+// to render the docs and to check it against the router
+type apiSpec struct {
+	Info struct {
+		Title       string `json:"title"`
+		Version     string `json:"version"`
+		Description string `json:"description"`
+	} `json:"info"`
+	Paths      map[string]map[string]*apiOperation `json:"paths"`
+	Components struct {
+		Parameters map[string]apiParameter `json:"parameters"`
+		Responses  map[string]apiResponse  `json:"responses"`
+	} `json:"components"`
+}
+
+type apiOperation struct {
+	Summary     string                 `json:"summary"`
+	Description string                 `json:"description"`
+	Parameters  []apiParameter         `json:"parameters"`
+	Responses   map[string]apiResponse `json:"responses"`
+}
+
+type apiParameter struct {
+	Ref         string `json:"$ref"`
+	Name        string `json:"name"`
+	In          string `json:"in"`
+	Description string `json:"description"`
+	Schema      struct {
+		Type string        `json:"type"`
+		Enum []interface{} `json:"enum"`
+	} `json:"schema"`
+}
+
+type apiResponse struct {
+	Ref         string `json:"$ref"`
+	Description string `json:"description"`
+}
+
+// loadAPISpec reads the OpenAPI document and resolves the
+// references to shared parameters and responses
+func loadAPISpec(path string) ([]byte, *apiSpec, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var spec apiSpec
+	if err := json.Unmarshal(raw, &spec); err != nil {
+		return nil, nil, err
+	}
+
+	for path, operations := range spec.Paths {
+		for method, op := range operations {
+			for i, param := range op.Parameters {
+				if param.Ref == "" {
+					continue
+				}
+
+				resolved, ok := spec.Components.Parameters[strings.TrimPrefix(param.Ref, "#/components/parameters/")]
+				if !ok {
+					return nil, nil, fmt.Errorf("%s %s: unknown parameter %s", method, path, param.Ref)
+				}
+				op.Parameters[i] = resolved
+			}
+
+			for code, resp := range op.Responses {
+				if resp.Ref == "" {
+					continue
+				}
+
+				resolved, ok := spec.Components.Responses[strings.TrimPrefix(resp.Ref, "#/components/responses/")]
+				if !ok {
+					return nil, nil, fmt.Errorf("%s %s: unknown response %s", method, path, resp.Ref)
+				}
+				op.Responses[code] = resolved
+			}
+		}
+	}
+
+	return raw, &spec, nil
+}
+
+// checkAPISpec returns an error listing every route below /api/ that
+// is registered on router but missing from spec
+func checkAPISpec(router *mux.Router, spec *apiSpec) error {
+	var missing []string
+	err := router.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		path, err := route.GetPathTemplate()
+		if err != nil || !strings.HasPrefix(path, apiPathPrefix) {
+			return nil
+		}
+
+		methods, err := route.GetMethods()
+		if err != nil {
+			// Subrouters have no methods of their own
+			if route.GetHandler() == nil {
+				return nil
+			}
+
+			methods = []string{http.MethodGet}
+		}
+
+		for _, method := range methods {
+			if _, ok := spec.Paths[path][strings.ToLower(method)]; !ok {
+				missing = append(missing, method+" "+path)
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return fmt.Errorf("routes missing from the api spec: %s", strings.Join(missing, ", "))
+	}
+
+	return nil
+}
+
+func (w *Web) handleAPISpec(rw http.ResponseWriter, r *http.Request) {
+	rw.Header().Set("Content-Type", "application/json")
+	if _, err := rw.Write(w.apiSpecRaw); err != nil {
+		ctxLog(r.Context()).Error().Err(err).Msg("could not write api spec")
+	}
+}
+
+func (w *Web) handleAPIDocs(rw http.ResponseWriter, r *http.Request) {
+	data, err := w.optionalTmplData(rw, r, w.apiSpec)
+	if err != nil {
+		w.handleError(rw, r, err)
+		return
+	}
+
+	w.templateExec(rw, r, "apidocs", data)
+}
@@ -0,0 +1,26 @@
+package web
+
+import (
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+// TestAPISpecComplete fails when a route below /api/ is missing from openapi.json
+func TestAPISpecComplete(t *testing.T) {
+	w := &Web{}
+	w.metrics = newMetrics(w)
+
+	router := mux.NewRouter()
+	w.Routes(router)
+
+	// Tests run in the package directory, not the repository root
+	_, spec, err := loadAPISpec("openapi.json")
+	if err != nil {
+		t.Fatalf("could not load api spec: %v", err)
+	}
+
+	if err := checkAPISpec(router, spec); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,64 @@
+package web
+
+import (
+	"net/http"
+	"strconv"
+)
+
+const resultsPerPage = 25
+
+// pagination describes which part of a list is shown on a page
+type pagination struct {
+	// Page is the current page, starting at 1
+	Page  int
+	Pages int
+	// Offset is the number of items on the pages before Page
+	Offset int
+}
+
+// paginate reads the page from the query of r, and returns it
+// together with the window of a list with n items to show on it
+func paginate(r *http.Request, n int) (*pagination, int, int) {
+	p := &pagination{Page: 1, Pages: (n + resultsPerPage - 1) / resultsPerPage}
+	if p.Pages == 0 {
+		p.Pages = 1
+	}
+
+	if page, err := strconv.Atoi(r.URL.Query().Get("page")); err == nil && page >= 1 && page <= p.Pages {
+		p.Page = page
+	}
+
+	p.Offset = (p.Page - 1) * resultsPerPage
+	end := p.Offset + resultsPerPage
+	if end > n {
+		end = n
+	}
+
+	return p, p.Offset, end
+}
+
+func (p *pagination) HasPrev() bool {
+	return p.Page > 1
+}
+
+func (p *pagination) HasNext() bool {
+	return p.Page < p.Pages
+}
+
+func (p *pagination) Prev() int {
+	return p.Page - 1
+}
+
+func (p *pagination) Next() int {
+	return p.Page + 1
+}
+
+// Numbers returns the numbers of all pages, for rendering links to them
+func (p *pagination) Numbers() []int {
+	numbers := make([]int, p.Pages)
+	for i := range numbers {
+		numbers[i] = i + 1
+	}
+
+	return numbers
+}
@@ -0,0 +1,338 @@
+package web
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/mdanie17/spotifytop/storage"
+	"github.com/zmb3/spotify/v2"
+)
+
+const (
+	// playlistModeReplace replaces the tracks of the playlist created
+	// before for the same time range and limit
+	playlistModeReplace = "replace"
+	// playlistModeAppend only adds the tracks that are not in that playlist yet
+	playlistModeAppend = "append"
+	// playlistModeCreate always creates a new playlist
+	playlistModeCreate = "create"
+
+	defaultPlaylistMode = playlistModeReplace
+
+	// The name and description of a playlist are templates where these
+	// placeholders are replaced when the playlist is saved
+	defaultPlaylistName        = "{user} Top {limit} tracks"
+	defaultPlaylistDescription = "{user} Top {limit} tracks - {range} | {action} {date}"
+
+	// Longest name and description accepted by spotify
+	maxPlaylistNameLength        = 100
+	maxPlaylistDescriptionLength = 300
+)
+
+// The {action} placeholder is replaced with what was last done to the playlist
+const (
+	playlistActionCreated   = "Created"
+	playlistActionUpdated   = "Updated"
+	playlistActionRefreshed = "Refreshed automatically, last"
+)
+
+var playlistModes = []string{playlistModeReplace, playlistModeAppend, playlistModeCreate}
+
+// errPlaylistDeleted is returned when a stored playlist was deleted by the user
+var errPlaylistDeleted = errors.New("playlist was deleted")
+
+// errPlaylistCollaborative is returned when a stored playlist would have to
+// change whether it is collaborative, spotify only sets that on creation
+var errPlaylistCollaborative = errors.New("collaborative can not be changed")
+
+// playlistResult describes what saveTopPlaylist did
+type playlistResult struct {
+	Playlist *spotify.FullPlaylist
+	// Created is false when an existing playlist was updated
+	Created bool
+	// Added is the number of tracks added to the playlist
+	Added int
+}
+
+// playlistOptions are chosen by the user when saving a playlist, Name and
+// Description are templates expanded by expandPlaylistTemplate, and the
+// defaults are used when they are empty
+type playlistOptions struct {
+	Name        string
+	Description string
+	Public      bool
+	// Collaborative is only applied when a playlist is created, spotify
+	// does not allow collaborative playlists to be public
+	Collaborative bool
+}
+
+func defaultPlaylistOptions() playlistOptions {
+	return playlistOptions{Name: defaultPlaylistName, Description: defaultPlaylistDescription}
+}
+
+// storedPlaylistOptions returns the options a stored playlist was saved with,
+// playlists saved before the options existed use the defaults
+func storedPlaylistOptions(stored storage.Playlist) playlistOptions {
+	options := playlistOptions{
+		Name:          stored.Name,
+		Description:   stored.Description,
+		Public:        stored.Public,
+		Collaborative: stored.Collaborative,
+	}
+
+	if options.Name == "" {
+		options.Name = defaultPlaylistName
+	}
+
+	if options.Description == "" {
+		options.Description = defaultPlaylistDescription
+	}
+
+	return options
+}
+
+// currentPlaylistOptions returns the options of the playlist stored for the
+// settings, so saving it again without choosing options keeps them
+func (w *Web) currentPlaylistOptions(userID string, settings Opts) (playlistOptions, error) {
+	stored, err := w.Storage.PlaylistGet(userID, settings.Timelimit, settings.Resultlimit)
+	if errors.Is(err, storage.ErrNotFound) {
+		return defaultPlaylistOptions(), nil
+	} else if err != nil {
+		return playlistOptions{}, err
+	}
+
+	return storedPlaylistOptions(stored), nil
+}
+
+// expandPlaylistTemplate replaces the placeholders {user}, {range}, {limit},
+// {date} and {action} in a playlist name or description
+func expandPlaylistTemplate(tmpl string, user *spotify.PrivateUser, settings Opts, action string, now time.Time) string {
+	year, month, day := now.Date()
+	return strings.NewReplacer(
+		"{user}", user.DisplayName,
+		"{range}", settings.TimeLimitFormatter(),
+		"{limit}", strconv.Itoa(settings.Resultlimit),
+		"{date}", fmt.Sprintf("%v %v %v", year, month, day),
+		"{action}", action,
+	).Replace(tmpl)
+}
+
+// checkPlaylistOptions validates the options, the lengths are checked after
+// expanding the templates since that is what is sent to spotify
+func checkPlaylistOptions(options playlistOptions, user *spotify.PrivateUser, settings Opts) error {
+	// The longest action and date, so a playlist that is valid now stays valid
+	longest := time.Date(2000, time.September, 30, 0, 0, 0, 0, time.UTC)
+	name := expandPlaylistTemplate(options.Name, user, settings, playlistActionRefreshed, longest)
+	if utf8.RuneCountInString(name) > maxPlaylistNameLength {
+		return errUser(fmt.Sprintf("The playlist name can be at most %d characters long, it is %d", maxPlaylistNameLength, utf8.RuneCountInString(name)))
+	}
+
+	description := expandPlaylistTemplate(options.Description, user, settings, playlistActionRefreshed, longest)
+	if utf8.RuneCountInString(description) > maxPlaylistDescriptionLength {
+		return errUser(fmt.Sprintf("The playlist description can be at most %d characters long, it is %d", maxPlaylistDescriptionLength, utf8.RuneCountInString(description)))
+	}
+
+	if strings.ContainsAny(description, "\r\n") {
+		return errUser("The playlist description can not contain line breaks")
+	}
+
+	if options.Public && options.Collaborative {
+		return errUser("A collaborative playlist can not be public")
+	}
+
+	return nil
+}
+
+func checkPlaylistMode(mode string) bool {
+	for _, valid := range playlistModes {
+		if mode == valid {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isSpotifyNotFound reports whether err is a 404 returned by spotify
+func isSpotifyNotFound(err error) bool {
+	var spotifyErr spotify.Error
+	return errors.As(err, &spotifyErr) && spotifyErr.Status == http.StatusNotFound
+}
+
+// saveTopPlaylist puts the top tracks of the user in a playlist. Unless mode is
+// playlistModeCreate, the playlist created before for the same settings is
+// updated, and a new one is only created if there is none or it was deleted.
+// The options are stored with the playlist, so later refreshes keep them.
+func (w *Web) saveTopPlaylist(ctx context.Context, client *spotify.Client, user *spotify.PrivateUser, settings Opts, mode string, options playlistOptions) (playlistResult, error) {
+	toptracks, err := w.topTracks(ctx, client, user.ID, settings, false)
+	if err != nil {
+		return playlistResult{}, err
+	}
+	stored, err := w.Storage.PlaylistGet(user.ID, settings.Timelimit, settings.Resultlimit)
+	if err != nil && !errors.Is(err, storage.ErrNotFound) {
+		return playlistResult{}, err
+	}
+
+	if err == nil && mode != playlistModeCreate {
+		if options.Collaborative != stored.Collaborative {
+			return playlistResult{}, errPlaylistCollaborative
+		}
+
+		stored.Name = options.Name
+		stored.Description = options.Description
+		stored.Public = options.Public
+
+		result, err := w.updateTopPlaylist(ctx, client, user, settings, mode, stored, toptracks)
+		if !errors.Is(err, errPlaylistDeleted) {
+			return result, err
+		}
+
+		ctxLog(ctx).Info().Str("playlist", stored.PlaylistID).Msg("stored playlist was deleted, creating a new one")
+	}
+
+	playlist, err := w.createTopPlaylist(ctx, client, user, settings, options, toptracks)
+	if err != nil {
+		return playlistResult{}, err
+	}
+
+	// The new playlist takes over the subscription of the one it replaces
+	now := time.Now()
+	err = w.Storage.PlaylistSave(storage.Playlist{
+		UserID:        user.ID,
+		TimeRange:     settings.Timelimit,
+		Limit:         settings.Resultlimit,
+		PlaylistID:    playlist.ID.String(),
+		Created:       now,
+		Updated:       now,
+		Subscribed:    stored.Subscribed,
+		Name:          options.Name,
+		Description:   options.Description,
+		Public:        options.Public,
+		Collaborative: options.Collaborative,
+	})
+	if err != nil {
+		// The playlist exists, it just will not be updated next time
+		ctxLog(ctx).Error().Err(err).Msg("could not save playlist")
+	}
+
+	return playlistResult{Playlist: playlist, Created: true, Added: len(toptracks)}, nil
+}
+
+// createTopPlaylist creates a playlist with the given top tracks of the user
+func (w *Web) createTopPlaylist(ctx context.Context, client *spotify.Client, user *spotify.PrivateUser, settings Opts, options playlistOptions, tracks []spotify.FullTrack) (*spotify.FullPlaylist, error) {
+	now := time.Now()
+	name := expandPlaylistTemplate(options.Name, user, settings, playlistActionCreated, now)
+	description := expandPlaylistTemplate(options.Description, user, settings, playlistActionCreated, now)
+
+	playlist, err := client.CreatePlaylistForUser(ctx, user.ID, name, description, options.Public, options.Collaborative)
+	if err != nil {
+		return nil, err
+	}
+	w.metrics.playlistsCreated.Inc()
+
+	_, err = client.AddTracksToPlaylist(ctx, playlist.ID, getTrackIDs(tracks)...)
+	if err != nil {
+		return nil, err
+	}
+
+	w.uploadCover(ctx, client, user, settings, playlist.ID, tracks)
+	return playlist, nil
+}
+
+// updateTopPlaylist replaces or appends to the tracks of a stored playlist and
+// applies its stored options, it returns errPlaylistDeleted if the user
+// deleted the playlist
+func (w *Web) updateTopPlaylist(ctx context.Context, client *spotify.Client, user *spotify.PrivateUser, settings Opts, mode string, stored storage.Playlist, tracks []spotify.FullTrack) (playlistResult, error) {
+	id := spotify.ID(stored.PlaylistID)
+	trackIDs := getTrackIDs(tracks)
+
+	// Deleting a playlist in spotify only unfollows it
+	follows, err := client.UserFollowsPlaylist(ctx, id, user.ID)
+	if isSpotifyNotFound(err) || (err == nil && len(follows) > 0 && !follows[0]) {
+		return playlistResult{}, errPlaylistDeleted
+	}
+	if err != nil {
+		return playlistResult{}, err
+	}
+
+	added := trackIDs
+	if mode == playlistModeAppend {
+		existing, err := playlistTrackIDs(ctx, client, id)
+		if err != nil {
+			return playlistResult{}, err
+		}
+
+		added = nil
+		for _, trackID := range trackIDs {
+			if !existing[trackID] {
+				added = append(added, trackID)
+			}
+		}
+
+		if len(added) > 0 {
+			if _, err := client.AddTracksToPlaylist(ctx, id, added...); err != nil {
+				return playlistResult{}, err
+			}
+		}
+	} else if err := client.ReplacePlaylistTracks(ctx, id, trackIDs...); err != nil {
+		return playlistResult{}, err
+	}
+
+	action := playlistActionUpdated
+	if stored.Subscribed {
+		action = playlistActionRefreshed
+	}
+
+	options := storedPlaylistOptions(stored)
+	now := time.Now()
+	name := expandPlaylistTemplate(options.Name, user, settings, action, now)
+	description := expandPlaylistTemplate(options.Description, user, settings, action, now)
+	public := options.Public && !options.Collaborative
+
+	if err := client.ChangePlaylistNameAccessAndDescription(ctx, id, name, description, public); err != nil {
+		return playlistResult{}, err
+	}
+
+	stored.Updated = time.Now()
+	if err := w.Storage.PlaylistSave(stored); err != nil {
+		ctxLog(ctx).Error().Err(err).Msg("could not save playlist")
+	}
+
+	w.uploadCover(ctx, client, user, settings, id, tracks)
+
+	playlist, err := client.GetPlaylist(ctx, id)
+	if err != nil {
+		return playlistResult{}, err
+	}
+
+	return playlistResult{Playlist: playlist, Added: len(added)}, nil
+}
+
+// playlistTrackIDs returns the ids of all tracks in a playlist
+func playlistTrackIDs(ctx context.Context, client *spotify.Client, id spotify.ID) (map[spotify.ID]bool, error) {
+	page, err := client.GetPlaylistTracks(ctx, id, spotify.Limit(100))
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make(map[spotify.ID]bool)
+	for {
+		for _, item := range page.Tracks {
+			ids[item.Track.ID] = true
+		}
+
+		err := client.NextPage(ctx, page)
+		if errors.Is(err, spotify.ErrNoMorePages) {
+			return ids, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
@@ -0,0 +1,242 @@
+package web
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/zmb3/spotify/v2"
+)
+
+const (
+	releasePrecisionYear  = "year"
+	releasePrecisionMonth = "month"
+	releasePrecisionDay   = "day"
+
+	// releaseExtremes is the number of oldest and newest tracks shown
+	releaseExtremes = 3
+)
+
+// releaseTrack is a top track with its parsed release date
+type releaseTrack struct {
+	rankedItem
+	Rank      int
+	Released  time.Time
+	Precision string
+}
+
+type releaseProfile struct {
+	profileRange
+	// Tracks is the number of tracks with a known release date, Unknown the rest
+	Tracks  int
+	Unknown int
+	Years   []featureBucket
+	Decades []featureBucket
+	Oldest  []releaseTrack
+	Newest  []releaseTrack
+	// AverageYear is 0 when no release date is known
+	AverageYear int
+
+	YearChart   template.HTML
+	DecadeChart template.HTML
+}
+
+type releasesData struct {
+	TimeRange string
+	Ranges    []Opts
+	// Profiles has a profile per time range, in the order of ValidTimeLimits
+	Profiles []releaseProfile
+	// Decades are the decades of all profiles, oldest first
+	Decades  []string
+	Selected releaseProfile
+}
+
+// parseReleaseDate parses the release date of an album with the precision
+// spotify gives it, falling back to the length of the date when the precision
+// is missing. Only the fields covered by the precision are meaningful.
+func parseReleaseDate(album spotify.SimpleAlbum) (time.Time, string, bool) {
+	layouts := map[string]string{
+		releasePrecisionYear:  "2006",
+		releasePrecisionMonth: "2006-01",
+		releasePrecisionDay:   "2006-01-02",
+	}
+
+	precision := album.ReleaseDatePrecision
+	if _, ok := layouts[precision]; !ok {
+		switch len(album.ReleaseDate) {
+		case len("2006"):
+			precision = releasePrecisionYear
+		case len("2006-01"):
+			precision = releasePrecisionMonth
+		default:
+			precision = releasePrecisionDay
+		}
+	}
+
+	date, err := time.Parse(layouts[precision], album.ReleaseDate)
+	if err != nil || date.Year() == 0 {
+		// Some releases only have their year right, e.g. "1970-00-00"
+		if len(album.ReleaseDate) < 4 {
+			return time.Time{}, "", false
+		}
+
+		year, err := strconv.Atoi(album.ReleaseDate[:4])
+		if err != nil || year == 0 {
+			return time.Time{}, "", false
+		}
+
+		return time.Date(year, time.January, 1, 0, 0, 0, 0, time.UTC), releasePrecisionYear, true
+	}
+
+	return date, precision, true
+}
+
+// Date formats the release date with its precision
+func (t releaseTrack) Date() string {
+	switch t.Precision {
+	case releasePrecisionDay:
+		return t.Released.Format("2 January 2006")
+	case releasePrecisionMonth:
+		return t.Released.Format("January 2006")
+	default:
+		return t.Released.Format("2006")
+	}
+}
+
+// DecadeCounts returns the number of tracks in each of the decades, in order
+func (p releaseProfile) DecadeCounts(decades []string) []int {
+	counts := make([]int, len(decades))
+	for i, decade := range decades {
+		for _, bucket := range p.Decades {
+			if bucket.Label == decade {
+				counts[i] = bucket.Count
+			}
+		}
+	}
+
+	return counts
+}
+
+func decadeLabel(year int) string {
+	return fmt.Sprintf("%ds", year/10*10)
+}
+
+// countBuckets turns the counts per label into buckets, sorted by label
+func countBuckets(counts map[string]int) []featureBucket {
+	buckets := make([]featureBucket, 0, len(counts))
+	for label, count := range counts {
+		buckets = append(buckets, featureBucket{Label: label, Count: count})
+	}
+
+	sort.Slice(buckets, func(i, j int) bool {
+		return buckets[i].Label < buckets[j].Label
+	})
+
+	return buckets
+}
+
+// buildReleaseProfile counts the top tracks per release year and decade
+func buildReleaseProfile(timeRange string, tracks []spotify.FullTrack) releaseProfile {
+	profile := releaseProfile{profileRange: profileRange{timeRange}}
+	years := make(map[string]int)
+	decades := make(map[string]int)
+	var released []releaseTrack
+	var yearSum int
+
+	ranked := tracksToRanked(tracks)
+	for i, track := range tracks {
+		date, precision, ok := parseReleaseDate(track.Album)
+		if !ok {
+			profile.Unknown++
+			continue
+		}
+
+		years[strconv.Itoa(date.Year())]++
+		decades[decadeLabel(date.Year())]++
+		yearSum += date.Year()
+
+		released = append(released, releaseTrack{
+			rankedItem: ranked[i],
+			Rank:       i + 1,
+			Released:   date,
+			Precision:  precision,
+		})
+	}
+
+	profile.Tracks = len(released)
+	profile.Years = countBuckets(years)
+	profile.Decades = countBuckets(decades)
+	if len(released) == 0 {
+		return profile
+	}
+
+	profile.AverageYear = (yearSum + len(released)/2) / len(released)
+
+	sort.SliceStable(released, func(i, j int) bool {
+		return released[i].Released.Before(released[j].Released)
+	})
+
+	n := releaseExtremes
+	if n > len(released) {
+		n = len(released)
+	}
+
+	profile.Oldest = released[:n]
+	for i := len(released) - 1; i >= len(released)-n; i-- {
+		profile.Newest = append(profile.Newest, released[i])
+	}
+
+	return profile
+}
+
+// renderCharts renders the year and decade distributions as svg charts
+func (p *releaseProfile) renderCharts() {
+	p.YearChart = barChart(bucketBars(p.Years))
+	p.DecadeChart = barChart(bucketBars(p.Decades))
+}
+
+// handleReleases shows when the top tracks of every time range were released,
+// and the distributions of the selected one
+func (w *Web) handleReleases(rw http.ResponseWriter, r *http.Request, rc *requestContext) error {
+	timeRange := r.URL.Query().Get("range")
+	if timeRange == "" {
+		timeRange = rc.Settings.Timelimit
+	}
+
+	if !checkTimelimit(timeRange) {
+		return errUser(fmt.Sprintf("Time range has to be one of %v", ValidTimeLimits))
+	}
+
+	lists, err := w.topTracksAllRanges(r.Context(), rc)
+	if err != nil {
+		return errSpotify(err, "could not get current user top tracks")
+	}
+
+	profiles := make([]releaseProfile, len(lists))
+	for i, tracks := range lists {
+		profiles[i] = buildReleaseProfile(ValidTimeLimits[i], tracks)
+	}
+
+	data := releasesData{TimeRange: timeRange, Ranges: timeRanges(), Profiles: profiles}
+	decades := make(map[string]int)
+	for _, profile := range profiles {
+		for _, bucket := range profile.Decades {
+			decades[bucket.Label] += bucket.Count
+		}
+
+		if profile.TimeRange == timeRange {
+			data.Selected = profile
+			data.Selected.renderCharts()
+		}
+	}
+
+	for _, bucket := range countBuckets(decades) {
+		data.Decades = append(data.Decades, bucket.Label)
+	}
+
+	w.templateExec(rw, r, "releases", rc.tmplData(data))
+	return nil
+}
@@ -0,0 +1,151 @@
+package web
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/mdanie17/spotifytop/scheduler"
+	"github.com/mdanie17/spotifytop/storage"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	defaultSnapshotConcurrency = 2
+)
+
+type scheduleData struct {
+	// Enabled is false when no snapshot schedule is configured
+	Enabled bool
+	OptedIn bool
+	Since   time.Time
+	Status  scheduler.Status
+	// User is the result of the last run for the user, if any
+	User *scheduler.UserStatus
+	// Users and Failing count the users run since the server started, and
+	// how many of them failed their last run
+	Users   int
+	Failing int
+}
+
+// mustParseSchedule parses a schedule from the config, and exits if it is invalid
+func mustParseSchedule(name, spec string) *scheduler.Schedule {
+	schedule, err := scheduler.ParseSchedule(spec)
+	if err != nil {
+		log.Fatal().Err(err).Str("name", name).Msg("invalid schedule")
+	}
+
+	if schedule.Next(time.Now()).IsZero() {
+		log.Fatal().Str("name", name).Str("schedule", spec).Msg("schedule never runs")
+	}
+
+	return schedule
+}
+
+// newScheduler builds the scheduler that snapshots the users who opted in
+func (w *Web) newScheduler() *scheduler.Scheduler {
+	return &scheduler.Scheduler{
+		Schedule:    mustParseSchedule("snapshot", w.SnapshotSchedule),
+		Jitter:      w.SnapshotJitter,
+		Concurrency: w.SnapshotConcurrency,
+		Users:       w.Storage.ScheduledUserList,
+		Job:         w.snapshotUser,
+	}
+}
+
+// snapshotUser fetches every top list of the user with their stored
+// spotify token, which records todays snapshots of them
+func (w *Web) snapshotUser(ctx context.Context, userID string) error {
+	logger := log.With().Str("user", w.anonymizeUserID(userID)).Logger()
+	ctx = logger.WithContext(ctx)
+
+	err := w.fetchAllTopLists(ctx, userID)
+	if err != nil {
+		w.metrics.scheduledSnapshots.WithLabelValues("error").Inc()
+		logger.Error().Err(err).Msg("could not snapshot user")
+		return err
+	}
+
+	w.metrics.scheduledSnapshots.WithLabelValues("success").Inc()
+	logger.Info().Msg("snapshotted user")
+	return nil
+}
+
+func (w *Web) fetchAllTopLists(ctx context.Context, userID string) error {
+	session, err := w.storedSession(userID)
+	if err != nil {
+		return fmt.Errorf("could not get stored session: %w", err)
+	}
+
+	for _, timelimit := range ValidTimeLimits {
+		settings := Opts{Timelimit: timelimit, Resultlimit: maxResultLimit}
+		if _, err := w.topArtists(ctx, session.Client, userID, settings, true); err != nil {
+			return fmt.Errorf("could not get top artists %s: %w", timelimit, err)
+		}
+
+		if _, err := w.topTracks(ctx, session.Client, userID, settings, true); err != nil {
+			return fmt.Errorf("could not get top tracks %s: %w", timelimit, err)
+		}
+	}
+
+	return nil
+}
+
+// handleSchedule shows if the user is opted in to background snapshots,
+// and the result of their last run
+func (w *Web) handleSchedule(rw http.ResponseWriter, r *http.Request, rc *requestContext) error {
+	data := scheduleData{Enabled: w.scheduler != nil}
+
+	user, err := w.Storage.ScheduledUserGet(rc.User.ID)
+	switch {
+	case err == nil:
+		data.OptedIn = true
+		data.Since = user.Created
+	case !errors.Is(err, storage.ErrNotFound):
+		return errInternal(err, "could not get scheduled user")
+	}
+
+	if w.scheduler != nil {
+		data.Status = w.scheduler.Status()
+		data.Users = len(data.Status.Users)
+		for _, status := range data.Status.Users {
+			if status.LastError != "" {
+				data.Failing++
+			}
+
+			if status.UserID == rc.User.ID {
+				status := status
+				status.Duration = status.Duration.Round(time.Millisecond)
+				data.User = &status
+			}
+		}
+
+		// Only the result of the user and the counts are shown
+		data.Status.Users = nil
+	}
+
+	w.templateExec(rw, r, "schedule", rc.tmplData(data))
+	return nil
+}
+
+func (w *Web) handleScheduleOptIn(rw http.ResponseWriter, r *http.Request, rc *requestContext) error {
+	if err := w.Storage.ScheduledUserSave(rc.User.ID); err != nil {
+		return errInternal(err, "could not save scheduled user")
+	}
+
+	w.addFlash(rw, r, flashMessage{flashLevelSuccess, "Your top lists will be snapshotted in the background"})
+	http.Redirect(rw, r, "/schedule", http.StatusSeeOther)
+	return nil
+}
+
+func (w *Web) handleScheduleOptOut(rw http.ResponseWriter, r *http.Request, rc *requestContext) error {
+	if err := w.Storage.ScheduledUserDelete(rc.User.ID); err != nil {
+		return errInternal(err, "could not delete scheduled user")
+	}
+
+	w.addFlash(rw, r, flashMessage{flashLevelSuccess, "Your top lists will no longer be snapshotted in the background"})
+	http.Redirect(rw, r, "/schedule", http.StatusSeeOther)
+	return nil
+}
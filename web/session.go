@@ -0,0 +1,157 @@
+package web
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/zmb3/spotify/v2"
+	"golang.org/x/oauth2"
+)
+
+var userRefreshInterval = 30 * time.Minute
+
+// Session is a logged in user, identified by the state cookie
+type Session struct {
+	Client *spotify.Client
+
+	mu sync.Mutex
+	// User is the profile of the logged in user, it is fetched
+	// after login and refreshed every userRefreshInterval
+	User        *spotify.PrivateUser
+	UserFetched time.Time
+}
+
+func (w *Web) sessionGet(state string) (*Session, bool) {
+	w.clientsLock.RLock()
+	defer w.clientsLock.RUnlock()
+
+	session, ok := w.Clients[state]
+	return session, ok
+}
+
+func (w *Web) sessionSet(state string, session *Session) {
+	w.clientsLock.Lock()
+	defer w.clientsLock.Unlock()
+
+	w.Clients[state] = session
+}
+
+func (w *Web) sessionDelete(state string) {
+	w.clientsLock.Lock()
+	defer w.clientsLock.Unlock()
+
+	delete(w.Clients, state)
+}
+
+// sessionUser returns the profile of the user of the session,
+// fetching it from spotify if it is missing, stale or refresh is set
+func (w *Web) sessionUser(ctx context.Context, session *Session, refresh bool) (*spotify.PrivateUser, error) {
+	session.mu.Lock()
+	defer session.mu.Unlock()
+
+	if session.User != nil && !refresh && time.Since(session.UserFetched) < userRefreshInterval {
+		return session.User, nil
+	}
+
+	user, err := session.Client.CurrentUser(ctx)
+	if err != nil {
+		// Rather show a slightly outdated profile than nothing at all
+		if session.User != nil && !refresh {
+			ctxLog(ctx).Warn().Err(err).Msg("could not refresh user, using cached user")
+			return session.User, nil
+		}
+
+		return nil, err
+	}
+
+	session.User = user
+	session.UserFetched = time.Now()
+	return user, nil
+}
+
+// storedSession returns a session for the user built from their stored spotify
+// token, used when the user is not making the request from a browser
+func (w *Web) storedSession(userID string) (*Session, error) {
+	w.clientsLock.RLock()
+	session, ok := w.storedSessions[userID]
+	w.clientsLock.RUnlock()
+	if ok {
+		return session, nil
+	}
+
+	// The token is read without holding the lock, so reading it does not
+	// block every other request
+	token, err := w.Storage.SpotifyTokenGet(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	w.clientsLock.Lock()
+	defer w.clientsLock.Unlock()
+
+	// Keep the session that was stored while the token was read, it may
+	// have been built from a newer token
+	if session, ok := w.storedSessions[userID]; ok {
+		return session, nil
+	}
+
+	session = &Session{Client: w.newSpotifyClient(context.Background(), token, userID)}
+	w.storedSessions[userID] = session
+	return session, nil
+}
+
+// storedSessionSet replaces the stored session of the user with one built
+// from token, after the user logged in again
+func (w *Web) storedSessionSet(userID string, token *oauth2.Token) {
+	session := &Session{Client: w.newSpotifyClient(context.Background(), token, userID)}
+
+	w.clientsLock.Lock()
+	defer w.clientsLock.Unlock()
+
+	w.storedSessions[userID] = session
+}
+
+// savingTokenSource stores the spotify token of a user whenever it is refreshed
+type savingTokenSource struct {
+	w      *Web
+	userID string
+	src    oauth2.TokenSource
+
+	mu   sync.Mutex
+	last string
+}
+
+func (s *savingTokenSource) Token() (*oauth2.Token, error) {
+	token, err := s.src.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if token.AccessToken != s.last {
+		if err := s.w.Storage.SpotifyTokenSave(s.userID, token); err != nil {
+			log.Error().Err(err).Msg("could not save refreshed spotify token")
+		}
+
+		s.last = token.AccessToken
+	}
+
+	return token, nil
+}
+
+// newSpotifyClient returns a client for the user owning token, with
+// requests to spotify being recorded in the metrics. If userID is set,
+// the token is stored again whenever it is refreshed.
+func (w *Web) newSpotifyClient(ctx context.Context, token *oauth2.Token, userID string) *spotify.Client {
+	httpClient := w.Auth.Client(ctx, token)
+	if transport, ok := httpClient.Transport.(*oauth2.Transport); ok && userID != "" {
+		transport.Source = &savingTokenSource{w: w, userID: userID, src: transport.Source, last: token.AccessToken}
+	}
+
+	httpClient.Transport = &spotifyTransport{w.metrics, httpClient.Transport}
+	return spotify.New(httpClient)
+}
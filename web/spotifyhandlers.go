@@ -1,183 +1,135 @@
 package web
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
-	"time"
+	"strings"
 
 	"github.com/aidarkhanov/nanoid"
-	"github.com/rs/zerolog/log"
 	"github.com/zmb3/spotify/v2"
 	spotifyauth "github.com/zmb3/spotify/v2/auth"
 )
 
-func (w *Web) handleTopArtists(rw http.ResponseWriter, r *http.Request) {
-	state, err := w.cookieGetState(rw, r)
-	if err != nil {
-		w.addFlash(rw, r, flashMessage{flashLevelDanger, "You have to log in first"})
-		redirectReferer(rw, r)
-		return
-	}
-
-	client, ok := w.Clients[state]
-	if !ok {
-		w.addFlash(rw, r, flashMessage{flashLevelWarning, "Your session has expired, please log in again"})
-		http.Redirect(rw, r, "/", http.StatusFound)
-		return
-	}
-
-	user, err := client.CurrentUser(r.Context())
-	if err != nil {
-		log.Error().Err(err).Msgf("could not get user")
-		w.addFlash(rw, r, flashMessage{flashLevelDanger, "Could not communicate with Spotify - Try clearing cache and trying again"})
-		redirectReferer(rw, r)
-		return
-	}
-
-	settings := w.cookieGetSettings(rw, r)
-
-	topartists, err := client.CurrentUsersTopArtists(
-		r.Context(),
-		spotify.Limit(settings.Resultlimit),
-		spotify.Timerange(spotify.Range(settings.Timelimit)),
-	)
+// topTracksData is a page of top tracks, with the options the playlist form
+// is prefilled with
+type topTracksData struct {
+	Tracks   []spotify.FullTrack
+	Playlist playlistOptions
+}
 
+func (w *Web) handleTopArtists(rw http.ResponseWriter, r *http.Request, rc *requestContext) error {
+	topartists, err := w.topArtists(r.Context(), rc.Client, rc.User.ID, rc.Settings, r.URL.Query().Get("refresh") != "")
 	if err != nil {
-		log.Error().Err(err).Msg("could not get current user top artists")
-		w.addFlash(rw, r, flashMessage{flashLevelDanger, "Could not communicate with Spotify - Try clearing cache and trying again"})
-		redirectReferer(rw, r)
-		return
+		return errSpotify(err, "could not get current user top artists")
 	}
 
-	Data := TmplData{
-		Result:   topartists.Artists,
-		Settings: Opts{settings.Timelimit, settings.Resultlimit},
-		User:     user.User,
-		LoggedIn: true,
-	}
+	pagination, start, end := paginate(r, len(topartists))
+	data := rc.tmplData(topartists[start:end])
+	data.Pagination = pagination
 
-	w.templateExec(rw, r, "topartists", Data)
+	w.templateExec(rw, r, "topartists", data)
+	return nil
 }
 
-func (w *Web) handleTopTracks(rw http.ResponseWriter, r *http.Request) {
-	state, err := w.cookieGetState(rw, r)
+func (w *Web) handleTopTracks(rw http.ResponseWriter, r *http.Request, rc *requestContext) error {
+	toptracks, err := w.topTracks(r.Context(), rc.Client, rc.User.ID, rc.Settings, r.URL.Query().Get("refresh") != "")
 	if err != nil {
-		w.addFlash(rw, r, flashMessage{flashLevelDanger, "You have to log in first"})
-		redirectReferer(rw, r)
-		return
+		return errSpotify(err, "could not get current user top tracks")
 	}
 
-	client, ok := w.Clients[state]
-	if !ok {
-		w.addFlash(rw, r, flashMessage{flashLevelWarning, "Your session has expired, please log in again"})
-		http.Redirect(rw, r, "/", http.StatusFound)
-		return
-	}
-
-	user, err := client.CurrentUser(r.Context())
+	// Saving the playlist again keeps the options it was saved with
+	options, err := w.currentPlaylistOptions(rc.User.ID, rc.Settings)
 	if err != nil {
-		log.Error().Err(err).Msgf("could not get user")
-		w.addFlash(rw, r, flashMessage{flashLevelDanger, "Could not communicate with Spotify - Try clearing cache and trying again"})
-		redirectReferer(rw, r)
-		return
+		return errInternal(err, "could not get playlist")
 	}
 
-	settings := w.cookieGetSettings(rw, r)
-
-	toptracks, err := client.CurrentUsersTopTracks(
-		r.Context(),
-		spotify.Limit(settings.Resultlimit),
-		spotify.Timerange(spotify.Range(settings.Timelimit)),
-	)
+	pagination, start, end := paginate(r, len(toptracks))
+	data := rc.tmplData(topTracksData{Tracks: toptracks[start:end], Playlist: options})
+	data.Pagination = pagination
 
-	if err != nil {
-		log.Error().Err(err).Msg("could not get current user top tracks")
-		w.addFlash(rw, r, flashMessage{flashLevelDanger, "Could not communicate with Spotify - Try clearing cache and trying again"})
-		redirectReferer(rw, r)
-		return
-	}
+	w.templateExec(rw, r, "toptracks", data)
+	return nil
+}
 
-	Data := TmplData{
-		Result:   toptracks.Tracks,
-		Settings: Opts{settings.Timelimit, settings.Resultlimit},
-		User:     user.User,
-		LoggedIn: true,
+// authScopes returns the scopes requested from the user when logging in
+func (w *Web) authScopes() []string {
+	scopes := []string{spotifyauth.ScopeUserTopRead, spotifyauth.ScopeUserReadPrivate, spotifyauth.ScopePlaylistModifyPrivate, spotifyauth.ScopePlaylistModifyPublic}
+	if w.PlaylistCover {
+		scopes = append(scopes, spotifyauth.ScopeImageUpload)
 	}
 
-	w.templateExec(rw, r, "toptracks", Data)
+	return scopes
 }
 
 func (w *Web) handleAuth(rw http.ResponseWriter, r *http.Request) {
 	state := nanoid.New()
 	cookieSetState(rw, r, state)
-	w.Auth = spotifyauth.New(
-		spotifyauth.WithRedirectURL(fmt.Sprintf("%s/authenticated", w.RedirectHost)),
-		spotifyauth.WithScopes(spotifyauth.ScopeUserTopRead, spotifyauth.ScopeUserReadPrivate, spotifyauth.ScopePlaylistModifyPrivate),
-		spotifyauth.WithClientID(w.Clientkey),
-		spotifyauth.WithClientSecret(w.Secretkey),
-	)
 
 	http.Redirect(rw, r, w.Auth.AuthURL(state), http.StatusFound)
 }
 
-//TODO(mdask) Maybe look for if playlist already exists, and overwrite it??
-func (w *Web) handleCreatePlaylist(rw http.ResponseWriter, r *http.Request) {
-	state, err := w.cookieGetState(rw, r)
-	if err != nil {
-		w.addFlash(rw, r, flashMessage{flashLevelDanger, "You have to log in first"})
-		redirectReferer(rw, r)
-		return
+// handleCreatePlaylist saves the top tracks of the user in a playlist with the
+// posted name, description and visibility, the mode selects if an existing
+// playlist is replaced or appended to
+func (w *Web) handleCreatePlaylist(rw http.ResponseWriter, r *http.Request, rc *requestContext) error {
+	if err := r.ParseForm(); err != nil {
+		return errUser("Could not read the form")
 	}
 
-	client, ok := w.Clients[state]
-	if !ok {
-		w.addFlash(rw, r, flashMessage{flashLevelWarning, "Your session has expired, please log in again"})
-		http.Redirect(rw, r, "/", http.StatusFound)
-		return
+	mode := r.PostFormValue("mode")
+	if mode == "" {
+		mode = defaultPlaylistMode
 	}
 
-	user, err := client.CurrentUser(r.Context())
-	if err != nil {
-		log.Error().Err(err).Msgf("could not get user")
-		w.addFlash(rw, r, flashMessage{flashLevelDanger, "Could not communicate with Spotify - Try clearing cache and trying again"})
-		redirectReferer(rw, r)
-		return
+	if !checkPlaylistMode(mode) {
+		return errUser(fmt.Sprintf("Playlist mode has to be one of %v", playlistModes))
 	}
 
-	settings := w.cookieGetSettings(rw, r)
+	options := playlistOptions{
+		Name:          strings.TrimSpace(r.PostFormValue("name")),
+		Description:   strings.TrimSpace(r.PostFormValue("description")),
+		Public:        r.PostFormValue("public") != "",
+		Collaborative: r.PostFormValue("collaborative") != "",
+	}
+	if options.Name == "" {
+		options.Name = defaultPlaylistName
+	}
 
-	playlistname := fmt.Sprintf("%s Top %d tracks", user.DisplayName, settings.Resultlimit)
-	creationyear, creationmonth, creationday := time.Now().Date()
-	playlistdesc := fmt.Sprintf("%s Top %d tracks - %s | Created %v %v %v", user.DisplayName, settings.Resultlimit, settings.TimeLimitFormatter(), creationyear, creationmonth, creationday)
+	if options.Description == "" {
+		options.Description = defaultPlaylistDescription
+	}
 
-	playlist, err := client.CreatePlaylistForUser(r.Context(), user.ID, playlistname, playlistdesc, false, false)
-	if err != nil {
-		log.Error().Err(err).Msg("could not create playlist")
-		w.addFlash(rw, r, flashMessage{flashLevelDanger, "Could not communicate with Spotify - Try clearing cache and trying again"})
-		redirectReferer(rw, r)
-		return
+	if err := checkPlaylistOptions(options, rc.User, rc.Settings); err != nil {
+		return err
 	}
 
-	toptracks, err := client.CurrentUsersTopTracks(
-		r.Context(),
-		spotify.Limit(settings.Resultlimit),
-		spotify.Timerange(spotify.Range(settings.Timelimit)),
-	)
-	if err != nil {
-		log.Error().Err(err).Msg("could not create playlist")
-		w.addFlash(rw, r, flashMessage{flashLevelDanger, "Could not communicate with Spotify - Try clearing cache and trying again"})
-		redirectReferer(rw, r)
-		return
+	result, err := w.saveTopPlaylist(r.Context(), rc.Client, rc.User, rc.Settings, mode, options)
+	if errors.Is(err, errPlaylistCollaborative) {
+		return errUser("Whether the playlist is collaborative can only be chosen when a new playlist is created")
+	} else if err != nil {
+		return errSpotify(err, "could not save playlist")
 	}
 
-	_, err = client.AddTracksToPlaylist(r.Context(), playlist.ID, getTrackIDs(toptracks.Tracks)...)
-	if err != nil {
-		log.Error().Err(err).Msg("could not create playlist")
-		w.addFlash(rw, r, flashMessage{flashLevelDanger, "Could not communicate with Spotify - Try clearing cache and trying again"})
-		redirectReferer(rw, r)
-		return
+	message := "Succesfully created playlist"
+	if !result.Created && mode == playlistModeAppend {
+		message = fmt.Sprintf("Added %d new tracks to playlist %s", result.Added, result.Playlist.Name)
+	} else if !result.Created {
+		message = fmt.Sprintf("Replaced the tracks of playlist %s", result.Playlist.Name)
 	}
 
-	w.addFlash(rw, r, flashMessage{flashLevelSuccess, "Succesfully created playlist"})
+	w.addFlash(rw, r, flashMessage{flashLevelSuccess, message})
 	redirectReferer(rw, r)
+	return nil
+}
+
+func (w *Web) handleMe(rw http.ResponseWriter, r *http.Request, rc *requestContext) error {
+	user, err := w.sessionUser(r.Context(), rc.Session, r.URL.Query().Get("refresh") != "")
+	if err != nil {
+		return errSpotify(err, "could not get user")
+	}
+
+	rc.User = user
+	w.templateExec(rw, r, "me", rc.tmplData(user))
+	return nil
 }
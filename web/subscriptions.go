@@ -0,0 +1,218 @@
+package web
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/mdanie17/spotifytop/scheduler"
+	"github.com/mdanie17/spotifytop/storage"
+	"github.com/rs/zerolog/log"
+)
+
+type playlistsData struct {
+	Playlists []playlistView
+	Ranges    []Opts
+	// Enabled is false when no refresh schedule is configured
+	Enabled bool
+	Status  scheduler.Status
+	// User is the result of the last refresh of the playlists of the user, if any
+	User *scheduler.UserStatus
+}
+
+// playlistView is a stored playlist with its time range formatted for display
+type playlistView struct {
+	storage.Playlist
+	Range string
+}
+
+// newPlaylistScheduler builds the scheduler that refreshes subscribed playlists
+func (w *Web) newPlaylistScheduler() *scheduler.Scheduler {
+	return &scheduler.Scheduler{
+		Schedule:    mustParseSchedule("playlist refresh", w.PlaylistRefreshSchedule),
+		Jitter:      w.SnapshotJitter,
+		Concurrency: w.SnapshotConcurrency,
+		Users:       w.Storage.PlaylistSubscribedUsers,
+		Job:         w.refreshPlaylists,
+	}
+}
+
+// refreshPlaylists replaces the tracks of every subscribed playlist of the
+// user with their current top tracks, using their stored spotify token. A
+// playlist that fails does not stop the others, the errors are returned joined.
+func (w *Web) refreshPlaylists(ctx context.Context, userID string) error {
+	logger := log.With().Str("user", w.anonymizeUserID(userID)).Logger()
+	ctx = logger.WithContext(ctx)
+
+	session, err := w.storedSession(userID)
+	if err != nil {
+		return fmt.Errorf("could not get stored session: %w", err)
+	}
+
+	user, err := w.sessionUser(ctx, session, false)
+	if err != nil {
+		return fmt.Errorf("could not get user: %w", err)
+	}
+
+	playlists, err := w.Storage.PlaylistList(userID)
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, playlist := range playlists {
+		if !playlist.Subscribed {
+			continue
+		}
+
+		settings := Opts{Timelimit: playlist.TimeRange, Resultlimit: playlist.Limit}
+		toptracks, err := w.topTracks(ctx, session.Client, userID, settings, false)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("could not get top tracks %s: %w", settings.Timelimit, err))
+			continue
+		}
+
+		_, err = w.updateTopPlaylist(ctx, session.Client, user, settings, playlistModeReplace, playlist, toptracks)
+		if errors.Is(err, errPlaylistDeleted) {
+			// The user does not want the playlist anymore, so stop refreshing it
+			logger.Info().Str("playlist", playlist.PlaylistID).Msg("subscribed playlist was deleted, forgetting it")
+			if err := w.Storage.PlaylistDelete(userID, playlist.TimeRange, playlist.Limit); err != nil {
+				errs = append(errs, err)
+			}
+
+			continue
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("could not refresh playlist %s: %w", playlist.PlaylistID, err))
+			continue
+		}
+
+		logger.Info().Str("playlist", playlist.PlaylistID).Msg("refreshed playlist")
+	}
+
+	return joinErrors(errs)
+}
+
+// joinErrors returns nil if there are no errors, the error if there is one,
+// and an error with all their messages otherwise
+func joinErrors(errs []error) error {
+	switch len(errs) {
+	case 0:
+		return nil
+	case 1:
+		return errs[0]
+	}
+
+	messages := make([]string, 0, len(errs))
+	for _, err := range errs {
+		messages = append(messages, err.Error())
+	}
+
+	return fmt.Errorf("%d errors: %s", len(errs), strings.Join(messages, "; "))
+}
+
+// parsePlaylistForm reads the time range and limit of a playlist from a form
+func parsePlaylistForm(r *http.Request) (Opts, error) {
+	if err := r.ParseForm(); err != nil {
+		return Opts{}, errUser("Could not read the form")
+	}
+
+	limit, err := strconv.Atoi(r.FormValue("limit"))
+	settings := Opts{Timelimit: r.FormValue("timelimit"), Resultlimit: limit}
+	if err != nil || !checkResultlimit(limit) || !checkTimelimit(settings.Timelimit) {
+		return Opts{}, errUser("You have to select a valid time range and number of tracks")
+	}
+
+	return settings, nil
+}
+
+// handlePlaylists lists the playlists created for the user, and lets them
+// subscribe playlists to be refreshed in the background
+func (w *Web) handlePlaylists(rw http.ResponseWriter, r *http.Request, rc *requestContext) error {
+	playlists, err := w.Storage.PlaylistList(rc.User.ID)
+	if err != nil {
+		return errInternal(err, "could not list playlists")
+	}
+
+	data := playlistsData{
+		Ranges:  timeRanges(),
+		Enabled: w.playlistScheduler != nil,
+	}
+
+	for _, playlist := range playlists {
+		data.Playlists = append(data.Playlists, playlistView{playlist, Opts{Timelimit: playlist.TimeRange}.TimeLimitFormatter()})
+	}
+
+	if w.playlistScheduler != nil {
+		data.Status = w.playlistScheduler.Status()
+		for _, status := range data.Status.Users {
+			if status.UserID == rc.User.ID {
+				status := status
+				data.User = &status
+			}
+		}
+
+		// Only the result of the user is shown
+		data.Status.Users = nil
+	}
+
+	w.templateExec(rw, r, "playlists", rc.tmplData(data))
+	return nil
+}
+
+// handlePlaylistSubscribe subscribes the playlist of the posted time range and
+// limit, creating it first if it does not exist
+func (w *Web) handlePlaylistSubscribe(rw http.ResponseWriter, r *http.Request, rc *requestContext) error {
+	settings, err := parsePlaylistForm(r)
+	if err != nil {
+		return err
+	}
+
+	playlist, err := w.Storage.PlaylistGet(rc.User.ID, settings.Timelimit, settings.Resultlimit)
+	if errors.Is(err, storage.ErrNotFound) {
+		if _, err := w.saveTopPlaylist(r.Context(), rc.Client, rc.User, settings, playlistModeCreate, defaultPlaylistOptions()); err != nil {
+			return errSpotify(err, "could not create playlist")
+		}
+
+		playlist, err = w.Storage.PlaylistGet(rc.User.ID, settings.Timelimit, settings.Resultlimit)
+	}
+	if err != nil {
+		return errInternal(err, "could not get playlist")
+	}
+
+	playlist.Subscribed = true
+	if err := w.Storage.PlaylistSave(playlist); err != nil {
+		return errInternal(err, "could not save playlist")
+	}
+
+	w.addFlash(rw, r, flashMessage{flashLevelSuccess, "The playlist will be refreshed automatically"})
+	http.Redirect(rw, r, "/playlists", http.StatusSeeOther)
+	return nil
+}
+
+// handlePlaylistUnsubscribe stops refreshing the playlist of the posted time range and limit
+func (w *Web) handlePlaylistUnsubscribe(rw http.ResponseWriter, r *http.Request, rc *requestContext) error {
+	settings, err := parsePlaylistForm(r)
+	if err != nil {
+		return err
+	}
+
+	playlist, err := w.Storage.PlaylistGet(rc.User.ID, settings.Timelimit, settings.Resultlimit)
+	if errors.Is(err, storage.ErrNotFound) {
+		return errUser("The playlist does not exist")
+	} else if err != nil {
+		return errInternal(err, "could not get playlist")
+	}
+
+	playlist.Subscribed = false
+	if err := w.Storage.PlaylistSave(playlist); err != nil {
+		return errInternal(err, "could not save playlist")
+	}
+
+	w.addFlash(rw, r, flashMessage{flashLevelSuccess, "The playlist will no longer be refreshed automatically"})
+	http.Redirect(rw, r, "/playlists", http.StatusSeeOther)
+	return nil
+}
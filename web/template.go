@@ -1,8 +1,8 @@
 package web
 
 import (
+	"html/template"
 	"net/http"
-	"text/template"
 
 	"github.com/rs/zerolog/log"
 )
@@ -12,6 +12,9 @@ const (
 	templatesExt      = ".tmpl"
 )
 
+// templateNames are the templates parsed on startup
+var templateNames = []string{"topartists", "frontpage", "toptracks", "me", "tokens", "apidocs", "compare", "history", "diff", "schedule", "playlists", "discover", "genres", "features", "topalbums", "releases"}
+
 func (w *Web) parseTemplate(name, path string) {
 	if path == "" {
 		path = name
@@ -45,7 +48,8 @@ func (w *Web) templateExec(rw http.ResponseWriter, r *http.Request, name string,
 	}
 
 	if err := w.templateGet(name).ExecuteTemplate(rw, "base", tmplData); err != nil {
-		log.Error().Err(err).Str("name", name).Interface("data", data).Msg("failed to view template")
+		ctxLog(r.Context()).Error().Err(err).Str("name", name).Interface("data", data).Msg("failed to view template")
+		w.metrics.templateErrors.WithLabelValues(name).Inc()
 		rw.WriteHeader(http.StatusInternalServerError)
 		return
 	}
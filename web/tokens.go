@@ -0,0 +1,232 @@
+package web
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aidarkhanov/nanoid"
+	"github.com/mdanie17/spotifytop/storage"
+)
+
+const (
+	apiTokenPrefix  = "stp_"
+	apiTokenMaxName = 64
+)
+
+// apiTokenExpiries are the lifetimes in days a user can choose for a new token
+var apiTokenExpiries = []int{7, 30, 90, 365}
+
+var (
+	errInvalidToken = &webError{
+		Level:   flashLevelDanger,
+		Message: "The api token is invalid or has expired",
+		Status:  http.StatusUnauthorized,
+	}
+	errMissingScope = &webError{
+		Level:   flashLevelDanger,
+		Message: "The api token does not have the scope needed for this request",
+		Status:  http.StatusForbidden,
+	}
+	errNoSpotifyToken = &webError{
+		Level:   flashLevelDanger,
+		Message: "Spotify access for this token is gone, please log in again",
+		Status:  http.StatusUnauthorized,
+	}
+)
+
+type tokensData struct {
+	Tokens []storage.APIToken
+	// NewToken is the plain text of a token that was just created, it is only shown once
+	NewToken string
+	Scopes   []string
+	Expiries []int
+}
+
+func hashAPIToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// newAPIToken returns a new random token in plain text
+func newAPIToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return apiTokenPrefix + base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// bearerToken returns the token from the Authorization header, if any
+func bearerToken(r *http.Request) (string, bool) {
+	header := r.Header.Get("Authorization")
+	if !strings.HasPrefix(header, "Bearer ") {
+		return "", false
+	}
+
+	return strings.TrimSpace(strings.TrimPrefix(header, "Bearer ")), true
+}
+
+// loadTokenRequestContext authenticates a request made with an api token, using
+// the stored spotify token of the user owning it
+func (w *Web) loadTokenRequestContext(ctx context.Context, token, scope string) (*requestContext, error) {
+	apitoken, err := w.Storage.APITokenGet(hashAPIToken(token))
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil, errInvalidToken
+	} else if err != nil {
+		return nil, errInternal(err, "could not get api token")
+	}
+
+	if apitoken.Expired() {
+		return nil, errInvalidToken
+	}
+
+	if !apitoken.HasScope(scope) {
+		return nil, errMissingScope
+	}
+
+	session, err := w.storedSession(apitoken.UserID)
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil, errNoSpotifyToken
+	} else if err != nil {
+		return nil, errInternal(err, "could not get spotify token")
+	}
+
+	user, err := w.sessionUser(ctx, session, false)
+	if err != nil {
+		return nil, errSpotify(err, "could not get user")
+	}
+
+	return &requestContext{
+		Session:  session,
+		Client:   session.Client,
+		User:     user,
+		Settings: Opts{defaultTimeLimit, defaultResultLimit},
+	}, nil
+}
+
+func (w *Web) tokensTmplData(rc *requestContext, newToken string) (TmplData, error) {
+	tokens, err := w.Storage.APITokenList(rc.User.ID)
+	if err != nil {
+		return TmplData{}, errInternal(err, "could not list api tokens")
+	}
+
+	return rc.tmplData(tokensData{
+		Tokens:   tokens,
+		NewToken: newToken,
+		Scopes:   storage.ValidScopes,
+		Expiries: apiTokenExpiries,
+	}), nil
+}
+
+func (w *Web) handleTokens(rw http.ResponseWriter, r *http.Request, rc *requestContext) error {
+	data, err := w.tokensTmplData(rc, "")
+	if err != nil {
+		return err
+	}
+
+	w.templateExec(rw, r, "tokens", data)
+	return nil
+}
+
+func (w *Web) handleTokenCreate(rw http.ResponseWriter, r *http.Request, rc *requestContext) error {
+	if err := r.ParseForm(); err != nil {
+		return errUser("Could not read the form")
+	}
+
+	name := strings.TrimSpace(r.FormValue("name"))
+	if name == "" || len(name) > apiTokenMaxName {
+		return errUser("The token needs a name of at most " + strconv.Itoa(apiTokenMaxName) + " characters")
+	}
+
+	var scopes []string
+	for _, scope := range r.Form["scope"] {
+		if !checkScope(scope) {
+			return errUser("You have to select valid scopes")
+		}
+
+		scopes = append(scopes, scope)
+	}
+
+	if len(scopes) == 0 {
+		return errUser("The token needs at least one scope")
+	}
+
+	days, err := strconv.Atoi(r.FormValue("expiry"))
+	if err != nil || !checkExpiry(days) {
+		return errUser("You have to select a valid expiry")
+	}
+
+	token, err := newAPIToken()
+	if err != nil {
+		return errInternal(err, "could not generate api token")
+	}
+
+	now := time.Now()
+	err = w.Storage.APITokenSave(storage.APIToken{
+		ID:      nanoid.New(),
+		Hash:    hashAPIToken(token),
+		UserID:  rc.User.ID,
+		Name:    name,
+		Scopes:  scopes,
+		Created: now,
+		Expires: now.AddDate(0, 0, days),
+	})
+	if err != nil {
+		return errInternal(err, "could not save api token")
+	}
+
+	// Render the page directly, as this is the only time the token is shown
+	data, err := w.tokensTmplData(rc, token)
+	if err != nil {
+		return err
+	}
+
+	w.templateExec(rw, r, "tokens", data)
+	return nil
+}
+
+func (w *Web) handleTokenRevoke(rw http.ResponseWriter, r *http.Request, rc *requestContext) error {
+	if err := r.ParseForm(); err != nil {
+		return errUser("Could not read the form")
+	}
+
+	err := w.Storage.APITokenDelete(rc.User.ID, r.FormValue("id"))
+	if errors.Is(err, storage.ErrNotFound) {
+		return errUser("The token does not exist")
+	} else if err != nil {
+		return errInternal(err, "could not delete api token")
+	}
+
+	w.addFlash(rw, r, flashMessage{flashLevelSuccess, "Succesfully revoked token"})
+	http.Redirect(rw, r, "/tokens", http.StatusSeeOther)
+	return nil
+}
+
+func checkScope(scope string) bool {
+	for _, valid := range storage.ValidScopes {
+		if scope == valid {
+			return true
+		}
+	}
+
+	return false
+}
+
+func checkExpiry(days int) bool {
+	for _, valid := range apiTokenExpiries {
+		if days == valid {
+			return true
+		}
+	}
+
+	return false
+}
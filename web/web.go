@@ -1,24 +1,30 @@
 package web
 
 import (
+	"context"
 	"encoding/gob"
 	"fmt"
+	"html/template"
 	"net/http"
 	"os"
 	"strconv"
 	"strings"
-	"text/template"
+	"sync"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/gorilla/sessions"
+	"github.com/mdanie17/spotifytop/scheduler"
+	"github.com/mdanie17/spotifytop/storage"
 	"github.com/rs/zerolog/log"
-	"github.com/zmb3/spotify/v2"
 	spotifyauth "github.com/zmb3/spotify/v2/auth"
 )
 
 var (
 	defaultTimeLimit      = "medium_term"
 	defaultResultLimit    = 20
+	defaultDatabasePath   = "spotifytop.db"
+	maxResultLimit        = spotifyMaxOffset + spotifyPageLimit
 	cookieKeyFlashMessage = "flash-session"
 )
 
@@ -47,15 +53,91 @@ type Web struct {
 	Clientkey    string
 	Secretkey    string
 
-	Clients map[string]*spotify.Client
+	// Clients maps the state cookie of a logged in user to their session
+	Clients     map[string]*Session
+	clientsLock sync.RWMutex
+	// storedSessions maps a user id to a session built from their stored
+	// spotify token, for requests that are not made from a browser
+	storedSessions map[string]*Session
+
+	// DatabasePath is where Storage is opened if it is not set
+	DatabasePath string
+	Storage      *storage.Storage
+
+	// SnapshotRetention specifies how long snapshots of the top lists are kept,
+	// 0 keeps them forever
+	SnapshotRetention time.Duration
+
+	// SnapshotSchedule is a cron-like schedule on which the top lists of users
+	// who opted in are snapshotted, empty disables the scheduler
+	SnapshotSchedule string
+	// SnapshotJitter is the maximum random delay before a user is snapshotted,
+	// it is also used when refreshing subscribed playlists, 0 disables it
+	SnapshotJitter time.Duration
+	// SnapshotConcurrency is the number of users snapshotted at the same time,
+	// it is also used when refreshing subscribed playlists
+	SnapshotConcurrency int
+	scheduler           *scheduler.Scheduler
+	// PlaylistRefreshSchedule is a cron-like schedule on which subscribed
+	// playlists are refreshed, empty disables refreshing them
+	PlaylistRefreshSchedule string
+	playlistScheduler       *scheduler.Scheduler
+	// GenreFamiliesPath is the file mapping micro-genres to their parent
+	// families on the genres page
+	GenreFamiliesPath string
+	genreFamilies     []genreFamily
+
+	// PlaylistCover uploads a generated cover to saved playlists, which
+	// requires the users to grant the ugc-image-upload scope
+	PlaylistCover bool
+
+	// CacheTTL specifies how long responses from the spotify top endpoints are cached
+	CacheTTL time.Duration
+	cache    *topCache
+
+	metrics *metrics
+
+	apiSpecRaw []byte
+	apiSpec    *apiSpec
+
+	// ReadyCheckSpotify makes /readyz check that the spotify accounts service resolves
+	ReadyCheckSpotify bool
 }
 
 func (w *Web) New() {
+	if w.metrics == nil {
+		w.metrics = newMetrics(w)
+	}
+
 	if w.Router == nil {
 		w.Router = mux.NewRouter()
 		w.Routes(w.Router)
 	}
 
+	if w.apiSpec == nil {
+		var err error
+		if w.apiSpecRaw, w.apiSpec, err = loadAPISpec(apiSpecPath); err != nil {
+			log.Fatal().Err(err).Msg("could not load api spec")
+		}
+	}
+
+	if w.GenreFamiliesPath == "" {
+		w.GenreFamiliesPath = defaultGenreFamiliesPath
+	}
+
+	if w.genreFamilies == nil {
+		var err error
+		if w.genreFamilies, err = loadGenreFamilies(w.GenreFamiliesPath); err != nil {
+			log.Fatal().Err(err).Str("path", w.GenreFamiliesPath).Msg("could not load genre families")
+		}
+	}
+
+	// TestAPISpecComplete catches undocumented api routes, this only
+	// warns about a spec that was changed without running the tests
+	if err := checkAPISpec(w.Router, w.apiSpec); err != nil {
+		log.Warn().Err(err).Msg("api spec is out of date")
+	}
+
 	if w.CookieKey == nil {
 		log.Fatal().Msg("no cookiekey specified")
 	}
@@ -77,9 +159,9 @@ func (w *Web) New() {
 	if w.Templates == nil {
 		w.Templates = make(map[string]*template.Template)
 
-		w.parseTemplate("topartists", "")
-		w.parseTemplate("frontpage", "")
-		w.parseTemplate("toptracks", "")
+		for _, name := range templateNames {
+			w.parseTemplate(name, "")
+		}
 	}
 
 	if w.State == "" {
@@ -107,26 +189,114 @@ func (w *Web) New() {
 	}
 
 	if w.Clients == nil {
-		w.Clients = make(map[string]*spotify.Client)
+		w.Clients = make(map[string]*Session)
+	}
+
+	if w.storedSessions == nil {
+		w.storedSessions = make(map[string]*Session)
+	}
+
+	if w.Auth == nil {
+		w.Auth = spotifyauth.New(
+			spotifyauth.WithRedirectURL(fmt.Sprintf("%s/authenticated", w.RedirectHost)),
+			spotifyauth.WithScopes(w.authScopes()...),
+			spotifyauth.WithClientID(w.Clientkey),
+			spotifyauth.WithClientSecret(w.Secretkey),
+		)
+	}
+
+	if w.DatabasePath == "" {
+		w.DatabasePath = defaultDatabasePath
+		log.Info().Msgf("empty database path, defaulting to %s", defaultDatabasePath)
+	}
+
+	if w.Storage == nil {
+		var err error
+		if w.Storage, err = storage.Open(w.DatabasePath); err != nil {
+			log.Fatal().Err(err).Str("path", w.DatabasePath).Msg("could not open database")
+		}
+	}
+
+	if w.CacheTTL == 0 {
+		w.CacheTTL = defaultCacheTTL
+		log.Info().Dur("ttl", w.CacheTTL).Msg("empty cache ttl, defaulting")
+	}
+
+	if w.cache == nil {
+		w.cache = newTopCache(w.CacheTTL)
+	}
+
+	if w.SnapshotConcurrency == 0 {
+		w.SnapshotConcurrency = defaultSnapshotConcurrency
+	}
+
+	if w.SnapshotSchedule != "" && w.scheduler == nil {
+		w.scheduler = w.newScheduler()
+	}
+
+	if w.PlaylistRefreshSchedule != "" && w.playlistScheduler == nil {
+		w.playlistScheduler = w.newPlaylistScheduler()
 	}
 }
 
 func (w *Web) Routes(r *mux.Router) {
+	r.Use(w.logRequests)
+	r.Use(w.metrics.measureRequests)
+
 	r.PathPrefix("/css/").Handler(http.StripPrefix("/css/", http.FileServer(http.Dir("./web/templates/css"))))
 
 	r.HandleFunc("/", w.handleFrontPage).Methods("GET")
 	// r.HandleFunc("/topartistsauth", w.handleAuthenticateArtists)
-	r.HandleFunc("/topartists", w.handleTopArtists)
+	r.HandleFunc("/topartists", w.requireLogin(w.handleTopArtists))
 	// r.HandleFunc("/toptracksauth", w.handleAuthenticateTracks)
-	r.HandleFunc("/toptracks", w.handleTopTracks)
-	r.HandleFunc("/createplaylist", w.handleCreatePlaylist)
+	r.HandleFunc("/toptracks", w.requireLogin(w.handleTopTracks))
+	r.HandleFunc("/topalbums", w.requireLogin(w.handleTopAlbums)).Methods("GET")
+	r.HandleFunc("/createplaylist", w.requireLogin(w.handleCreatePlaylist)).Methods("POST")
+	r.HandleFunc("/playlists", w.requireLogin(w.handlePlaylists)).Methods("GET")
+	r.HandleFunc("/playlists/subscribe", w.requireLogin(w.handlePlaylistSubscribe)).Methods("POST")
+	r.HandleFunc("/playlists/unsubscribe", w.requireLogin(w.handlePlaylistUnsubscribe)).Methods("POST")
+	r.HandleFunc("/me", w.requireLogin(w.handleMe))
+	r.HandleFunc("/compare", w.requireLogin(w.handleCompare)).Methods("GET")
+	r.HandleFunc("/discover", w.requireLogin(w.handleDiscover)).Methods("GET")
+	r.HandleFunc("/genres", w.requireLogin(w.handleGenres)).Methods("GET")
+	r.HandleFunc("/features", w.requireLogin(w.handleFeatures)).Methods("GET")
+	r.HandleFunc("/releases", w.requireLogin(w.handleReleases)).Methods("GET")
+	r.HandleFunc("/discover/playlist", w.requireLogin(w.handleDiscoverPlaylist)).Methods("POST")
+	r.HandleFunc("/history", w.requireLogin(w.handleHistory)).Methods("GET")
+	r.HandleFunc("/history/diff", w.requireLogin(w.handleHistoryDiff)).Methods("GET")
+	r.HandleFunc("/schedule", w.requireLogin(w.handleSchedule)).Methods("GET")
+	r.HandleFunc("/schedule/optin", w.requireLogin(w.handleScheduleOptIn)).Methods("POST")
+	r.HandleFunc("/schedule/optout", w.requireLogin(w.handleScheduleOptOut)).Methods("POST")
+	r.HandleFunc("/export/{kind}/{format}", w.requireLogin(w.handleExport)).Methods("GET")
+	r.HandleFunc("/tokens", w.requireLogin(w.handleTokens)).Methods("GET")
+	r.HandleFunc("/tokens/create", w.requireLogin(w.handleTokenCreate)).Methods("POST")
+	r.HandleFunc("/tokens/revoke", w.requireLogin(w.handleTokenRevoke)).Methods("POST")
 	r.HandleFunc("/form", w.handleForm)
 	r.HandleFunc("/login", w.handleAuth)
 	r.HandleFunc("/logout", w.handleLogout)
 	r.HandleFunc("/authenticated", w.handleAuthenticated)
+	r.Handle("/metrics", w.metrics.handler())
+	r.HandleFunc("/healthz", w.handleHealthz)
+	r.HandleFunc("/readyz", w.handleReadyz)
+
+	r.HandleFunc("/api/openapi.json", w.handleAPISpec).Methods("GET")
+	r.HandleFunc("/api/docs", w.handleAPIDocs).Methods("GET")
+	w.apiRoutes(r.PathPrefix("/api/v1").Subrouter())
 }
 
 func (w *Web) Run() {
+	if w.SnapshotRetention > 0 {
+		go w.pruneSnapshots()
+	}
+
+	if w.scheduler != nil {
+		go w.scheduler.Run(context.Background())
+	}
+
+	if w.playlistScheduler != nil {
+		go w.playlistScheduler.Run(context.Background())
+	}
+
 	log.Info().Msgf("Starting server on port %s:%s", w.ServerHostName, w.ServerPort)
 	if err := http.ListenAndServe(fmt.Sprintf("%s:%s", w.ServerHostName, w.ServerPort), w.Router); err != nil {
 		log.Fatal().Err(err).Msg("failed to start webserver")
@@ -134,38 +304,18 @@ func (w *Web) Run() {
 }
 
 func (w *Web) handleFrontPage(rw http.ResponseWriter, r *http.Request) {
-	settings := w.cookieGetSettings(rw, r)
-	state, err := w.cookieGetState(rw, r)
+	data, err := w.optionalTmplData(rw, r, nil)
 	if err != nil {
-		w.templateExec(rw, r, "frontpage", TmplData{Settings: settings, LoggedIn: false})
+		w.handleError(rw, r, err)
 		return
 	}
 
-	client, ok := w.Clients[state]
-	if !ok {
-		w.templateExec(rw, r, "frontpage", TmplData{Settings: settings, LoggedIn: false})
-		return
-	}
-
-	user, err := client.CurrentUser(r.Context())
-	if err != nil {
-		w.addFlash(rw, r, flashMessage{flashLevelDanger, "Could not communicate with Spotify - Try clearing cache and trying again"})
-		redirectReferer(rw, r)
-		return
-	}
-
-	Data := TmplData{
-		Settings: settings,
-		User:     user.User,
-		LoggedIn: true,
-	}
-	w.templateExec(rw, r, "frontpage", Data)
-
+	w.templateExec(rw, r, "frontpage", data)
 }
 
 func (w *Web) handleForm(rw http.ResponseWriter, r *http.Request) {
 	if err := r.ParseForm(); err != nil {
-		log.Error().Err(err).Msg("could not parse settings form")
+		ctxLog(r.Context()).Error().Err(err).Msg("could not parse settings form")
 		return
 	}
 
@@ -173,8 +323,7 @@ func (w *Web) handleForm(rw http.ResponseWriter, r *http.Request) {
 	resultlimit := r.FormValue("limit")
 	resultlimitint, err := strconv.Atoi(resultlimit)
 	if err != nil {
-		w.addFlash(rw, r, flashMessage{flashLevelWarning, "You have to select a valid number of results"})
-		resultlimitint = defaultResultLimit
+		resultlimitint = 0
 	}
 
 	w.cookieSetSettings(rw, r, Opts{timelimit, resultlimitint})
@@ -189,7 +338,10 @@ func (w *Web) handleAuthenticated(rw http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	w.createClient(rw, r, state)
+	if err := w.createClient(rw, r, state); err != nil {
+		w.addFlash(rw, r, flashMessage{flashLevelDanger, "Could not authenticate - Clear cache and try again"})
+	}
+
 	http.Redirect(rw, r, "/", http.StatusFound)
 }
 
@@ -198,9 +350,10 @@ func (w *Web) handleLogout(rw http.ResponseWriter, r *http.Request) {
 	if err != nil {
 		w.addFlash(rw, r, flashMessage{flashLevelDanger, "Something went wrong logging you out"})
 		http.Redirect(rw, r, "/", http.StatusFound)
+		return
 	}
 
-	w.Clients[state] = nil
+	w.sessionDelete(state)
 
 	w.deleteCookie(rw, r, "state")
 	w.addFlash(rw, r, flashMessage{flashLevelSuccess, "Successfully logged you out!"})